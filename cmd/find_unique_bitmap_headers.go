@@ -1,16 +1,21 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/depy/RevenantRE/graphics"
+	"github.com/depy/RevenantRE/vfs"
 )
 
 func main() {
-	path := flag.String("path", "", "Path to the directory to search for unique headers")
+	path := flag.String("path", "", "Path to the directory to search for unique headers, or with -a, the directory inside the layers (e.g. Imagery or .)")
+	layers := flag.String("a", "", "Directories to search, comma separated, later ones shadow earlier ones")
 	flag.Parse()
 
 	if *path == "" {
@@ -18,39 +23,70 @@ func main() {
 		return
 	}
 
-	uniqueHeaders := make(map[uint32]string)
+	var fsys fs.FS
+	root := "."
+	prefix := "" // Keeps printed paths the same as on disk when walking a plain directory
+	if *layers != "" {
+		lfs, err := vfs.Mount(strings.Split(*layers, ",")...)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer lfs.Close()
+		fsys = lfs
+		root = *path
+	} else {
+		fsys = os.DirFS(*path)
+		prefix = *path
+	}
+
+	uniqueHeaders := make(map[graphics.BitmapFlagSet]string)
+	chunkTypes := make(map[uint32]int)        // Chunked bitmaps of each ChunksHeader.Type
+	chunkTypeFiles := make(map[uint32]string) // First file with each type
+	compTypes := make(map[uint8]int)          // Resources of each FileResourceHeader.CompType
+	versions := make(map[uint8]int)           // and Version
+	sizeMismatches := []string{}              // Resources where DataSize != ObjSize
+	failed := []error{}
 
-	err := filepath.Walk(*path, func(path string, info os.FileInfo, err error) error {
+	err := fs.WalkDir(fsys, root, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			fmt.Println(err)
 			return err
 		}
 
-		if info.IsDir() ||
-			filepath.Ext(path) == ".bmp" ||
-			filepath.Ext(path) == ".TN" ||
-			filepath.Ext(path) == ".tn" ||
-			filepath.Ext(path) == ".def" ||
-			filepath.Ext(path) == ".wav" ||
-			filepath.Ext(path) == ".mp3" ||
-			filepath.Ext(path) == ".s" ||
-			filepath.Base(path) == "quickload.dat" {
+		if d.IsDir() {
 			return nil
 		}
 
 		//fmt.Println("Processing file: ", path)
-		file, err := os.Open(path)
+		file, err := vfs.OpenFile(fsys, path)
 		if err != nil {
 			fmt.Println(err)
 			return err
 		}
 		defer file.Close()
 
-		fmt.Println("Creating file resource for: ", path)
 		fr, err := graphics.NewFileResource(file, true)
+		if errors.Is(err, graphics.ErrBadMagic) {
+			// Sounds, thumbnails, saves... anything that isn't a resource
+			return nil
+		}
+
+		if frh := fr.Header; frh.Magic == graphics.RESOURCE_MAGIC {
+			compTypes[frh.CompType]++
+			versions[frh.Version]++
+			if frh.DataSize != frh.ObjSize {
+				sizeMismatches = append(sizeMismatches, fmt.Sprintf("%s: DataSize %d, ObjSize %d, CompType %d", filepath.Join(prefix, path), frh.DataSize, frh.ObjSize, frh.CompType))
+			}
+		}
+
 		if err != nil {
+			// Keep going, one broken file shouldn't stop the census. Count
+			// whatever bitmaps were read before the error.
 			fmt.Println(err)
-			return err
+			failed = append(failed, err)
+		} else {
+			fmt.Println("Created file resource for: ", filepath.Join(prefix, path))
 		}
 
 		//fmt.Println("Found bitmaps: ", len(fr.Bitmaps))
@@ -60,7 +96,14 @@ func main() {
 			//fmt.Println("Extracted headers flags", fval, path)
 			if _, ok := uniqueHeaders[fval]; !ok {
 				//fmt.Println("Unique header: ", fval, " found in ", path)
-				uniqueHeaders[fval] = path
+				uniqueHeaders[fval] = filepath.Join(prefix, path)
+			}
+
+			if f := graphics.NewBitmapFlags(fval); f.IsCompressed && f.IsChunked && !f.NoBitmap {
+				if chunkTypes[bm.ChunkType] == 0 {
+					chunkTypeFiles[bm.ChunkType] = filepath.Join(prefix, path)
+				}
+				chunkTypes[bm.ChunkType]++
 			}
 		}
 
@@ -71,10 +114,37 @@ func main() {
 		fmt.Println(err)
 	}
 
+	if len(failed) > 0 {
+		fmt.Println("Failed to parse", len(failed), "files:")
+		for _, err := range failed {
+			fmt.Println("  ", err)
+		}
+	}
+
+	fmt.Println("Compression types:")
+	for t, n := range compTypes {
+		fmt.Printf("   %d: %d resources\n", t, n)
+	}
+	fmt.Println("Versions:")
+	for v, n := range versions {
+		fmt.Printf("   %d: %d resources\n", v, n)
+	}
+	fmt.Println("DataSize != ObjSize in", len(sizeMismatches), "resources")
+	for _, m := range sizeMismatches {
+		fmt.Println("  ", m)
+	}
+
+	fmt.Println("Chunk types:")
+	for t, n := range chunkTypes {
+		fmt.Printf("   %d: %d bitmaps, e.g. %s\n", t, n, chunkTypeFiles[t])
+	}
+
 	for k, v := range uniqueHeaders {
-		f := graphics.NewBitmapFlags(k)
 		fmt.Println("----- ", v, " -----")
-		graphics.PrintBitmapFlags(&f)
+		fmt.Println("Flags:\t\t", k)
+		if u := k.Unknown(); u != 0 {
+			fmt.Println("Unknown flags:\t", u)
+		}
 		fmt.Println("----------------------------------------")
 	}
 }
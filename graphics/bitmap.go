@@ -1,30 +1,48 @@
 package graphics
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
-	"os"
+	"image"
+	"io"
+	"math"
 
 	"github.com/depy/RevenantRE/utils"
 )
 
+const (
+	BMH_SIZE = 72 // Seems like the header is 72 bytes when there's no chunking header following
+
+	// Positions of the offset fields in the bitmap header
+	BMH_ALIAS_POS   = 32
+	BMH_ALPHA_POS   = 40
+	BMH_ZBUFFER_POS = 48
+	BMH_NORMAL_POS  = 56
+	BMH_PALETTE_POS = 64
+
+	// Largest width or height that gets decoded. The game's biggest images
+	// are screen sized, anything past this is a corrupt header.
+	MAX_BITMAP_DIMENSION = 4096
+)
+
 // Bitmap Flags
 const (
-	BM_8BIT       uint16 = 0x0001 // Bitmap data is 8 bit.
-	BM_15BIT      uint16 = 0x0002 // Bitmap data is 15 bit.
-	BM_16BIT      uint16 = 0x0004 // Bitmap data is 16 bit.
-	BM_24BIT      uint16 = 0x0008 // Bitmap data is 24 bit.
-	BM_32BIT      uint16 = 0x0010 // Bitmap data is 24 bit.
-	BM_ZBUFFER    uint16 = 0x0020 // Bitmap has ZBuffer.
-	BM_NORMALS    uint16 = 0x0040 // Bitmap has Normal Buffer.
-	BM_ALIAS      uint16 = 0x0080 // Bitmap has Alias Buffer.
-	BM_ALPHA      uint16 = 0x0100 // Bitmap has Alpha Buffer.
-	BM_PALETTE    uint16 = 0x0200 // Bitmap has 256 Color SPalette Structure.
-	BM_REGPOINT   uint16 = 0x0400 // Bitmap has registration point
-	BM_NOBITMAP   uint16 = 0x0800 // Bitmap has no pixel data
-	BM_5BITPAL    uint16 = 0x1000 // Bitmap palette is 5 bit for r,g,b instead of 8 bit
-	BM_COMPRESSED uint16 = 0x4000 // Bitmap is compressed.
-	BM_CHUNKED    uint32 = 0x8000 // Bitmap is chunked out
+	BM_8BIT       BitmapFlagSet = 0x0001 // Bitmap data is 8 bit.
+	BM_15BIT      BitmapFlagSet = 0x0002 // Bitmap data is 15 bit.
+	BM_16BIT      BitmapFlagSet = 0x0004 // Bitmap data is 16 bit.
+	BM_24BIT      BitmapFlagSet = 0x0008 // Bitmap data is 24 bit.
+	BM_32BIT      BitmapFlagSet = 0x0010 // Bitmap data is 32 bit.
+	BM_ZBUFFER    BitmapFlagSet = 0x0020 // Bitmap has ZBuffer.
+	BM_NORMALS    BitmapFlagSet = 0x0040 // Bitmap has Normal Buffer.
+	BM_ALIAS      BitmapFlagSet = 0x0080 // Bitmap has Alias Buffer.
+	BM_ALPHA      BitmapFlagSet = 0x0100 // Bitmap has Alpha Buffer.
+	BM_PALETTE    BitmapFlagSet = 0x0200 // Bitmap has 256 Color SPalette Structure.
+	BM_REGPOINT   BitmapFlagSet = 0x0400 // Bitmap has registration point
+	BM_NOBITMAP   BitmapFlagSet = 0x0800 // Bitmap has no pixel data
+	BM_5BITPAL    BitmapFlagSet = 0x1000 // Bitmap palette is 5 bit for r,g,b instead of 8 bit
+	BM_COMPRESSED BitmapFlagSet = 0x4000 // Bitmap is compressed.
+	BM_CHUNKED    BitmapFlagSet = 0x8000 // Bitmap is chunked out
 )
 
 type BitmapFlags struct {
@@ -57,8 +75,8 @@ type BitmapHeader struct {
 	Height        uint32
 	RegPointX     uint32
 	RegPointY     uint32
-	Flags         uint32
-	DrawingMode   uint32
+	Flags         BitmapFlagSet
+	DrawingMode   DrawingMode
 	KeyColor      uint32
 	AliasSize     uint32
 	AliasOffset   uint32
@@ -74,77 +92,56 @@ type BitmapHeader struct {
 }
 
 type Bitmap struct {
-	Width   uint32
-	Height  uint32
-	Header  BitmapHeader
-	Palette Palette
-	Data    []RGBA
-}
+	Width    uint32
+	Height   uint32
+	Header   BitmapHeader
+	Canvas   image.Rectangle // What the data decodes to, the whole chunk grid for chunked bitmaps
+	Content  image.Rectangle // The part of Canvas that Data holds, Width x Height
+	RegPoint image.Point     // Registration point, if BM_REGPOINT: the pixel drawn at the object's position
+	Palette  Palette
+	Data     []RGBA
+	Coverage []bool   // Which pixels of Data a compressed bitmap draws, the others are transparent. nil if it isn't compressed
+	Alias    []byte   // Edge coverage of each pixel of Header.Width*Header.Height, if BM_ALIAS, see AliasMask
+	Alpha    []byte   // Alpha buffer, one byte per pixel of Header.Width*Header.Height, if BM_ALPHA
+	ZBuffer  []uint16 // Depth of each pixel of Header.Width*Header.Height, if BM_ZBUFFER
+	Normals  []Normal // Surface normal of each pixel of Header.Width*Header.Height, if BM_NORMALS
+	Blend    BlendMode
 
-type Palette struct {
-	Colors []RGBA
+	ChunkType uint32 // ChunksHeader.Type of a chunked bitmap, read even when only reading headers
 }
 
-func NewPalette(data []byte) Palette {
-	p := Palette{}
-	for i := 0; i < len(data); i += 2 {
-		c := binary.LittleEndian.Uint16(data[i : i+2])
-		pR := uint8((c>>10)&0x1F) * 8
-		pG := uint8((c>>5)&0x1F) * 8
-		pB := uint8(c&0x1F) * 8
-		p.Colors = append(p.Colors, RGBA{pR, pG, pB, 255})
-	}
-	return p
-}
-
-func NewBitmapFlags(flags uint32) BitmapFlags {
+func NewBitmapFlags(flags BitmapFlagSet) BitmapFlags {
 	return BitmapFlags{
-		Is8bit:        flags&uint32(BM_8BIT) != 0,
-		Is15bit:       flags&uint32(BM_15BIT) != 0,
-		Is16bit:       flags&uint32(BM_16BIT) != 0,
-		Is24bit:       flags&uint32(BM_24BIT) != 0,
-		Is32bit:       flags&uint32(BM_32BIT) != 0,
-		HasZBuffer:    flags&uint32(BM_ZBUFFER) != 0,
-		HasNormals:    flags&uint32(BM_NORMALS) != 0,
-		HasAlias:      flags&uint32(BM_ALIAS) != 0,
-		HasAlpha:      flags&uint32(BM_ALPHA) != 0,
-		HasPalette:    flags&uint32(BM_PALETTE) != 0,
-		HasRegPoint:   flags&uint32(BM_REGPOINT) != 0,
-		NoBitmap:      flags&uint32(BM_NOBITMAP) != 0,
-		Is5bitPalette: flags&uint32(BM_5BITPAL) != 0,
-		IsCompressed:  flags&uint32(BM_COMPRESSED) != 0,
-		IsChunked:     flags&uint32(BM_CHUNKED) != 0,
+		Is8bit:        flags&BM_8BIT != 0,
+		Is15bit:       flags&BM_15BIT != 0,
+		Is16bit:       flags&BM_16BIT != 0,
+		Is24bit:       flags&BM_24BIT != 0,
+		Is32bit:       flags&BM_32BIT != 0,
+		HasZBuffer:    flags&BM_ZBUFFER != 0,
+		HasNormals:    flags&BM_NORMALS != 0,
+		HasAlias:      flags&BM_ALIAS != 0,
+		HasAlpha:      flags&BM_ALPHA != 0,
+		HasPalette:    flags&BM_PALETTE != 0,
+		HasRegPoint:   flags&BM_REGPOINT != 0,
+		NoBitmap:      flags&BM_NOBITMAP != 0,
+		Is5bitPalette: flags&BM_5BITPAL != 0,
+		IsCompressed:  flags&BM_COMPRESSED != 0,
+		IsChunked:     flags&BM_CHUNKED != 0,
 	}
 }
 
-func PrintBitmapFlags(flags *BitmapFlags) {
-	fmt.Println("----- Bitmap Flags -----")
-	fmt.Println("Is8bit:\t\t", flags.Is8bit)
-	fmt.Println("Is16bit:\t", flags.Is16bit)
-	fmt.Println("Is15bit:\t", flags.Is15bit)
-	fmt.Println("Is24bit:\t", flags.Is24bit)
-	fmt.Println("Is32bit:\t", flags.Is32bit)
-	fmt.Println("HasZBuffer:\t", flags.HasZBuffer)
-	fmt.Println("HasNormals:\t", flags.HasNormals)
-	fmt.Println("HasAlias:\t", flags.HasAlias)
-	fmt.Println("HasAlpha:\t", flags.HasAlpha)
-	fmt.Println("HasPalette:\t", flags.HasPalette)
-	fmt.Println("HasRegPoint:\t", flags.HasRegPoint)
-	fmt.Println("NoBitmap:\t", flags.NoBitmap)
-	fmt.Println("Is5bitPalette:\t", flags.Is5bitPalette)
-	fmt.Println("IsCompressed:\t", flags.IsCompressed)
-	fmt.Println("IsChunked:\t", flags.IsChunked)
-	fmt.Println("------------------------")
-}
+func NewBitmapHeader(data []byte) (BitmapHeader, error) {
+	if err := utils.CheckSize(data, BMH_SIZE); err != nil {
+		return BitmapHeader{}, err
+	}
 
-func NewBitmapHeader(data []byte) BitmapHeader {
 	return BitmapHeader{
 		Width:         binary.LittleEndian.Uint32(data[0:4]),
 		Height:        binary.LittleEndian.Uint32(data[4:8]),
 		RegPointX:     binary.LittleEndian.Uint32(data[8:12]),
 		RegPointY:     binary.LittleEndian.Uint32(data[12:16]),
-		Flags:         binary.LittleEndian.Uint32(data[16:20]),
-		DrawingMode:   binary.LittleEndian.Uint32(data[20:24]),
+		Flags:         BitmapFlagSet(binary.LittleEndian.Uint32(data[16:20])),
+		DrawingMode:   DrawingMode(binary.LittleEndian.Uint32(data[20:24])),
 		KeyColor:      binary.LittleEndian.Uint32(data[24:28]),
 		AliasSize:     binary.LittleEndian.Uint32(data[28:32]),
 		AliasOffset:   binary.LittleEndian.Uint32(data[32:36]),
@@ -157,7 +154,7 @@ func NewBitmapHeader(data []byte) BitmapHeader {
 		PaletteSize:   binary.LittleEndian.Uint32(data[60:64]),
 		PaletteOffset: binary.LittleEndian.Uint32(data[64:68]),
 		DataSize:      binary.LittleEndian.Uint32(data[68:72]),
-	}
+	}, nil
 }
 
 func PrintBitmapHeader(bmh *BitmapHeader) {
@@ -183,100 +180,453 @@ func PrintBitmapHeader(bmh *BitmapHeader) {
 	fmt.Println("------------------------")
 }
 
-func NewBitmap(file *os.File, readOnlyHeaders bool) (Bitmap, error) {
-	bmhData, err := utils.ReadBytes(file, 72) // Seems like the header is 72 bytes when there's no chunking header following
+// NewBitmapFromBytes parses a bitmap that starts at the beginning of data.
+func NewBitmapFromBytes(data []byte, readOnlyHeaders bool) (Bitmap, error) {
+	return NewBitmap(bytes.NewReader(data), 0, readOnlyHeaders)
+}
+
+// NewBitmap parses the bitmap that starts at offset in file. Errors are
+// *ParseError. Bitmaps with flags that can't be rendered yet return an
+// error wrapping ErrUnsupportedFlags along with the parsed header, and
+// chunked ones of an unknown chunk type one wrapping ErrUnknownChunkType.
+func NewBitmap(file io.ReaderAt, offset int64, readOnlyHeaders bool) (Bitmap, error) {
+	budget := MAX_RESOURCE_PIXELS
+	return readBitmap(file, offset, "bitmap", readOnlyHeaders, &budget)
+}
+
+// readBitmap is NewBitmap with the name the ParseError Ops start with,
+// e.g. "bitmap 2" for the third bitmap of a resource. The pixels the
+// bitmap decodes to are taken from budget before decoding, and a bitmap
+// that needs more than is left fails with ErrTooLarge.
+func readBitmap(file io.ReaderAt, offset int64, name string, readOnlyHeaders bool, budget *int) (Bitmap, error) {
+	bmhData, err := utils.ReadBytes(file, offset, BMH_SIZE)
 	if err != nil {
-		fmt.Println("Error reading bitmap header for: ", file.Name())
-		return Bitmap{}, err
+		return Bitmap{}, &ParseError{utils.Name(file), name + " header", offset, err}
 	}
 
-	bmHeader := NewBitmapHeader(bmhData)
+	bmHeader, err := NewBitmapHeader(bmhData)
+	if err != nil {
+		return Bitmap{}, &ParseError{utils.Name(file), name + " header", offset, err}
+	}
 	//PrintBitmapHeader(&bmHeader)
 
 	bmFlags := NewBitmapFlags(bmHeader.Flags)
-	//PrintBitmapFlags(&bmFlags)
 
-	rgbData := []RGBA{}
-	palette := Palette{}
+	bounds := image.Rect(0, 0, int(bmHeader.Width), int(bmHeader.Height))
+	bm := Bitmap{Width: bmHeader.Width, Height: bmHeader.Height, Header: bmHeader, Canvas: bounds, Content: bounds, Data: []RGBA{}, Blend: NewBlendMode(bmHeader)}
+	if bmFlags.HasRegPoint {
+		bm.RegPoint = image.Pt(int(bmHeader.RegPointX), int(bmHeader.RegPointY))
+	}
+	if bmFlags.IsCompressed && bmFlags.IsChunked && !bmFlags.NoBitmap {
+		// Read even with readOnlyHeaders, the census counts chunk types
+		if data, err := utils.ReadBytes(file, offset+BMH_SIZE, 4); err == nil {
+			bm.ChunkType = binary.LittleEndian.Uint32(data)
+		}
+	}
+	if readOnlyHeaders || bmFlags.NoBitmap {
+		return bm, nil
+	}
+
+	dataOffset := offset + BMH_SIZE
+	if bmHeader.Width > MAX_BITMAP_DIMENSION || bmHeader.Height > MAX_BITMAP_DIMENSION {
+		return bm, &ParseError{utils.Name(file), name + " data", dataOffset,
+			fmt.Errorf("%w: %dx%d", ErrTooLarge, bmHeader.Width, bmHeader.Height)}
+	}
+	bmapData, err := utils.ReadBytes(file, dataOffset, int(bmHeader.DataSize))
+	if err != nil {
+		return bm, &ParseError{utils.Name(file), name + " data", dataOffset, err}
+	}
+
+	pixels := bitmapPixels(bmHeader, bmFlags, bmapData)
+	if pixels > *budget {
+		return bm, &ParseError{utils.Name(file), name + " data", dataOffset,
+			fmt.Errorf("%w: %d pixels, %d left of %d", ErrTooLarge, pixels, *budget, MAX_RESOURCE_PIXELS)}
+	}
+	*budget -= pixels
 
-	if !readOnlyHeaders {
-		bmapData, err := utils.ReadBytes(file, int(bmHeader.DataSize))
+	if bmFlags.Is15bit || bmFlags.Is16bit {
+		toRGBA := RGB565
+		if bmFlags.Is15bit {
+			toRGBA = RGB555
+		}
+
+		if bmFlags.IsCompressed && !bmFlags.IsChunked {
+			pixels, covered, err := DecompressPlain(bmapData, bmHeader.Width, bmHeader.Height, 2)
+			if pixels != nil {
+				bm.Data = renderWords(bmHeader, pixels, toRGBA)
+				setCoverage(&bm, covered)
+			}
+			if err != nil {
+				return bm, &ParseError{utils.Name(file), name + " data", dataOffset, err}
+			}
+		} else if bmFlags.IsCompressed {
+			chunks, err := Decompress(bmapData, bmFlags.IsChunked, 2)
+			if len(chunks.Chunks) > 0 {
+				setChunkedData(&bm, chunks, renderChunkedWords(bmHeader, chunks, toRGBA))
+			}
+			if err != nil {
+				return bm, &ParseError{utils.Name(file), name + " data", dataOffset, err}
+			}
+		} else {
+			if err := checkPixelData(bmHeader, bmapData, 2); err != nil {
+				return bm, &ParseError{utils.Name(file), name + " data", dataOffset, err}
+			}
+			bm.Data = renderWords(bmHeader, bmapData, toRGBA)
+		}
+	} else if (bmFlags.Is24bit || bmFlags.Is32bit) && !bmFlags.IsCompressed {
+		bytesPerPixel := 3
+		if bmFlags.Is32bit {
+			bytesPerPixel = 4
+		}
+		if err := checkPixelData(bmHeader, bmapData, bytesPerPixel); err != nil {
+			return bm, &ParseError{utils.Name(file), name + " data", dataOffset, err}
+		}
+		if bmFlags.Is32bit {
+			bm.Data = RenderBitmap32bit(bmHeader, bmapData)
+		} else {
+			bm.Data = RenderBitmap24bit(bmHeader, bmapData)
+		}
+	} else if bmFlags.Is8bit {
+		bm.Palette, err = readPalette(file, offset, name, bmHeader, bmFlags)
 		if err != nil {
-			fmt.Println("Error reading bitmap data for: ", file.Name())
-			return Bitmap{}, err
+			return bm, err
 		}
 
-		if bmFlags.Is15bit {
-			rgbData = RenderBitmap15bit(bmHeader, bmapData)
-		} else if bmFlags.Is8bit {
-			paletteData, err := utils.ReadBytes(file, 512)
-			palette = NewPalette(paletteData)
+		if bmFlags.IsCompressed && !bmFlags.IsChunked {
+			pixels, covered, err := DecompressPlain(bmapData, bmHeader.Width, bmHeader.Height, 1)
+			if pixels != nil {
+				bm.Data = RenderBitmap8bit(bmHeader, pixels, bm.Palette)
+				setCoverage(&bm, covered)
+			}
+			if err != nil {
+				return bm, &ParseError{utils.Name(file), name + " data", dataOffset, err}
+			}
+		} else if bmFlags.IsCompressed {
+			chunks, err := Decompress(bmapData, bmFlags.IsChunked, 1)
+			if len(chunks.Chunks) > 0 {
+				// Render even when some chunks are corrupt, so they can be inspected
+				setChunkedData(&bm, chunks, RenderChunkedBitmap8bit(bmHeader, chunks, bm.Palette))
+			}
 			if err != nil {
-				fmt.Println("Error reading palette data for: ", file.Name())
-				return Bitmap{}, err
+				return bm, &ParseError{utils.Name(file), name + " data", dataOffset, err}
 			}
-			if bmFlags.IsCompressed {
-				chunks := Decompress(bmapData, bmFlags.IsChunked)
-				bmHeader.Width = chunks.ChunksHeader.Width * CHUNK_WIDTH
-				bmHeader.Height = chunks.ChunksHeader.Height * CHUNK_HEIGHT
-				rgbData = RenderChunkedBitmap8bit(bmHeader, chunks, palette)
-			} else {
-				rgbData = RenderBitmap8bit(bmHeader, bmapData, palette)
+		} else {
+			if err := checkPixelData(bmHeader, bmapData, 1); err != nil {
+				return bm, &ParseError{utils.Name(file), name + " data", dataOffset, err}
 			}
+			bm.Data = RenderBitmap8bit(bmHeader, bmapData, bm.Palette)
+		}
+	} else {
+		return bm, &ParseError{utils.Name(file), name + " data", dataOffset, fmt.Errorf("%w: %v", ErrUnsupportedFlags, bmHeader.Flags)}
+	}
+
+	if bmFlags.HasPalette && !bmFlags.Is8bit && bmHeader.PaletteSize > 0 {
+		// Not needed to render the pixels, but kept like the other buffers
+		bm.Palette, err = readPalette(file, offset, name, bmHeader, bmFlags)
+		if err != nil {
+			return bm, err
+		}
+	}
+
+	if bmFlags.HasAlias && bmHeader.AliasSize > 0 {
+		aliasOffset := headerOffset(offset, BMH_ALIAS_POS, bmHeader.AliasOffset)
+		if uint64(bmHeader.AliasSize) != uint64(bmHeader.Width)*uint64(bmHeader.Height) {
+			return bm, &ParseError{utils.Name(file), name + " alias", aliasOffset,
+				fmt.Errorf("%w: %d alias bytes for %dx%d pixels", ErrBadBufferSize, bmHeader.AliasSize, bmHeader.Width, bmHeader.Height)}
 		}
+		alias, err := readBuffer(file, aliasOffset, bmHeader.AliasSize, int(bmHeader.Width*bmHeader.Height))
+		if err != nil {
+			return bm, &ParseError{utils.Name(file), name + " alias", aliasOffset, err}
+		}
+		bm.Alias = alias
+	}
+
+	if bmFlags.HasAlpha && bmHeader.AlphaSize > 0 {
+		alphaOffset := headerOffset(offset, BMH_ALPHA_POS, bmHeader.Alpha)
+		alpha, err := readAlpha(file, alphaOffset, bmHeader)
+		if err != nil {
+			return bm, &ParseError{utils.Name(file), name + " alpha", alphaOffset, err}
+		}
+		bm.Alpha = alpha
+		ApplyAlpha(bm.Data, int(bm.Width), alpha, int(bm.Width), int(bm.Height))
+		if bm.Coverage != nil {
+			// Skipped pixels stay transparent whatever the alpha buffer says
+			setCoverage(&bm, bm.Coverage)
+		}
+	}
+
+	if bmFlags.HasZBuffer && bmHeader.ZBufferSize > 0 {
+		zOffset := headerOffset(offset, BMH_ZBUFFER_POS, bmHeader.ZBuffer)
+		zbuf, err := readZBuffer(file, zOffset, bmHeader)
+		if err != nil {
+			return bm, &ParseError{utils.Name(file), name + " zbuffer", zOffset, err}
+		}
+		bm.ZBuffer = zbuf
 	}
 
-	return Bitmap{bmHeader.Width, bmHeader.Height, bmHeader, palette, rgbData}, nil
+	if bmFlags.HasNormals && bmHeader.NormalSize > 0 {
+		normalOffset := headerOffset(offset, BMH_NORMAL_POS, bmHeader.Normal)
+		normals, err := readNormals(file, normalOffset, bmHeader)
+		if err != nil {
+			return bm, &ParseError{utils.Name(file), name + " normals", normalOffset, err}
+		}
+		bm.Normals = normals
+	}
+
+	return bm, nil
 }
 
-func RenderBitmap15bit(bmh BitmapHeader, data []byte) []RGBA {
-	result := make([]RGBA, bmh.Width*bmh.Height)
+// bitmapPixels is how many pixels decoding the bitmap allocates: its
+// width*height, or for chunked bitmaps the whole chunk grid if that is
+// larger, as it is decoded before being cropped.
+func bitmapPixels(bmh BitmapHeader, flags BitmapFlags, data []byte) int {
+	pixels := uint64(bmh.Width) * uint64(bmh.Height)
+	if flags.IsCompressed && flags.IsChunked && len(data) >= 12 {
+		chunks := uint64(binary.LittleEndian.Uint32(data[4:8])) * uint64(binary.LittleEndian.Uint32(data[8:12]))
+		pixels = max(pixels, chunks*CHUNK_WIDTH*CHUNK_HEIGHT)
+	}
+	return int(min(pixels, math.MaxInt32))
+}
 
-	for i := range bmh.Height {
-		for j := range bmh.Width {
-			d := data[i*bmh.Width*2+j*2 : i*bmh.Width*2+j*2+2]
+// readPalette reads the palette of the bitmap at offset, see palettePosition.
+func readPalette(file io.ReaderAt, offset int64, name string, bmh BitmapHeader, flags BitmapFlags) (Palette, error) {
+	paletteOffset, paletteSize := palettePosition(offset, bmh)
+	paletteData, err := utils.ReadBytes(file, paletteOffset, paletteSize)
+	if err != nil {
+		return Palette{}, &ParseError{utils.Name(file), name + " palette", paletteOffset, err}
+	}
+	p, err := NewBitmapPalette(paletteData, flags.Is5bitPalette)
+	if err != nil {
+		return Palette{}, &ParseError{utils.Name(file), name + " palette", paletteOffset, err}
+	}
+	return p, nil
+}
 
-			pixelData := binary.LittleEndian.Uint16(d)
-			convPixelData := pixelData
-			pR := uint8((convPixelData&0b0111110000000000)>>10) << 3
-			pG := uint8((convPixelData&0b0000001111100000)>>5) << 3
-			pB := uint8((convPixelData & 0b0000000000011111)) << 3
-			pA := uint8(convPixelData & 0b1000000000000000)
+// headerOffset resolves an offset stored in the bitmap header. Like the
+// palette offset (palette_offset_from_here in docs/i2d_data_format.hexpat)
+// and the chunk offsets, they are relative to where the field itself is.
+func headerOffset(bitmapOffset int64, fieldPos int64, value uint32) int64 {
+	return bitmapOffset + fieldPos + int64(value)
+}
 
-			result[i*bmh.Width+j] = RGBA{pR, pG, pB, pA}
+func readAlpha(file io.ReaderAt, offset int64, bmh BitmapHeader) ([]byte, error) {
+	return readBuffer(file, offset, bmh.AlphaSize, int(bmh.Width*bmh.Height))
+}
+
+// readBuffer reads size bytes of a per pixel buffer whose header says it
+// is bufSize bytes long.
+func readBuffer(file io.ReaderAt, offset int64, bufSize uint32, size int) ([]byte, error) {
+	if int64(bufSize) < int64(size) {
+		return nil, &ErrTruncated{Offset: offset, Want: size, Got: int(bufSize)}
+	}
+	return utils.ReadBytes(file, offset, size)
+}
+
+// ApplyAlpha sets the alpha of each pixel from an alpha buffer of width*height
+// bytes. stride is the width of data, which can be wider than the alpha
+// buffer, e.g. a whole chunk grid.
+func ApplyAlpha(data []RGBA, stride int, alpha []byte, width int, height int) {
+	for y := range height {
+		for x := range width {
+			if i := y*stride + x; i < len(data) {
+				data[i].A = alpha[y*width+x]
+			}
+		}
+	}
+}
+
+// setChunkedData crops the rendered chunk grid to the bitmap's own size.
+// The content is in the top left corner, the rest of the grid is padding
+// up to the next multiple of the chunk size.
+func setChunkedData(bm *Bitmap, chunks ChunkedBitmapData, canvas []RGBA) {
+	stride := int(chunks.ChunksHeader.Width) * chunks.Format.Width
+	bm.Canvas = image.Rect(0, 0, stride, int(chunks.ChunksHeader.Height)*chunks.Format.Height)
+	bm.Data = cropPixels(canvas, stride, bm.Content)
+
+	covered := renderChunks(chunks, func(chunk *Chunk, dPos int) bool {
+		return dPos < len(chunk.Covered) && chunk.Covered[dPos]
+	})
+	setCoverage(bm, cropPixels(covered, stride, bm.Content))
+}
+
+// setCoverage makes the pixels a compressed bitmap doesn't draw transparent.
+func setCoverage(bm *Bitmap, covered []bool) {
+	bm.Coverage = covered
+	for i := range bm.Data {
+		if i >= len(covered) || !covered[i] {
+			bm.Data[i].A = 0
+		}
+	}
+}
+
+// cropPixels copies r out of pixels laid out stride wide. Parts of r
+// outside of pixels are left transparent.
+func cropPixels[T any](pixels []T, stride int, r image.Rectangle) []T {
+	result := make([]T, r.Dx()*r.Dy())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X && x < stride; x++ {
+			if i := y*stride + x; i < len(pixels) {
+				result[(y-r.Min.Y)*r.Dx()+x-r.Min.X] = pixels[i]
+			}
 		}
 	}
 	return result
 }
 
+// checkPixelData makes sure data holds a full width*height bitmap.
+func checkPixelData(bmh BitmapHeader, data []byte, bytesPerPixel int) error {
+	size := uint64(bmh.Width) * uint64(bmh.Height) * uint64(bytesPerPixel)
+	if uint64(len(data)) < size {
+		return &ErrTruncated{Want: int(min(size, math.MaxInt)), Got: len(data)}
+	}
+	return nil
+}
+
+// RenderBitmap15bit renders 1-5-5-5 pixels, see RGB555.
+func RenderBitmap15bit(bmh BitmapHeader, data []byte) []RGBA {
+	return renderWords(bmh, data, RGB555)
+}
+
 func RenderBitmap8bit(bmh BitmapHeader, data []byte, palette Palette) []RGBA {
 	result := make([]RGBA, bmh.Width*bmh.Height)
+	key, keyed := transparentKey(bmh)
 	for i := range bmh.Height {
 		for j := range bmh.Width {
 			d := data[i*bmh.Width+j]
 			c := palette.Colors[d]
+			if keyed && uint32(d) == key {
+				c.A = 0
+			}
 			result[i*bmh.Width+j] = RGBA{c.R, c.G, c.B, c.A}
 		}
 	}
 	return result
 }
 
+func RenderBitmap16bit(bmh BitmapHeader, data []byte) []RGBA {
+	return renderWords(bmh, data, RGB565)
+}
+
+// renderWords renders little endian 16 bit pixels converted by toRGBA.
+func renderWords(bmh BitmapHeader, data []byte, toRGBA func(uint16) RGBA) []RGBA {
+	result := make([]RGBA, bmh.Width*bmh.Height)
+	key, keyed := transparentKey(bmh)
+	mask := keyMask(bmh)
+	for i := range bmh.Width * bmh.Height {
+		c := binary.LittleEndian.Uint16(data[i*2 : i*2+2])
+		result[i] = toRGBA(c)
+		if keyed && uint32(c)&mask == key&mask {
+			result[i].A = 0
+		}
+	}
+	return result
+}
+
+// RenderBitmap24bit renders pixels stored as B, G, R bytes, the Windows
+// DIB order.
+func RenderBitmap24bit(bmh BitmapHeader, data []byte) []RGBA {
+	result := make([]RGBA, bmh.Width*bmh.Height)
+	key, keyed := transparentKey(bmh)
+	for i := range bmh.Width * bmh.Height {
+		d := data[i*3 : i*3+3]
+		result[i] = RGBA{d[2], d[1], d[0], 255}
+		if keyed && uint32(d[2])<<16|uint32(d[1])<<8|uint32(d[0]) == key {
+			result[i].A = 0
+		}
+	}
+	return result
+}
+
+// RenderBitmap32bit renders pixels stored as B, G, R, A bytes. Bitmaps
+// where every alpha byte is 0 don't use the alpha channel (X8R8G8B8) and
+// are rendered opaque. Like 24 bit ones, the key color is 0xRRGGBB.
+func RenderBitmap32bit(bmh BitmapHeader, data []byte) []RGBA {
+	result := make([]RGBA, bmh.Width*bmh.Height)
+	hasAlpha := false
+	for i := range bmh.Width * bmh.Height {
+		d := data[i*4 : i*4+4]
+		result[i] = RGBA{d[2], d[1], d[0], d[3]}
+		hasAlpha = hasAlpha || d[3] != 0
+	}
+
+	key, keyed := transparentKey(bmh)
+	for i, c := range result {
+		if !hasAlpha {
+			result[i].A = 255
+		}
+		if keyed && uint32(c.R)<<16|uint32(c.G)<<8|uint32(c.B) == key {
+			result[i].A = 0
+		}
+	}
+	return result
+}
+
+// RGB555 converts a 15 bit 1-5-5-5 pixel. The top bit isn't alpha, the
+// game draws every pixel that isn't the key color.
+func RGB555(c uint16) RGBA {
+	return RGBA{expand5(c >> 10), expand5(c >> 5), expand5(c), 255}
+}
+
+// RGB565 converts a 16 bit 5-6-5 pixel. 16 bit bitmaps have no alpha bit.
+func RGB565(c uint16) RGBA {
+	g := uint8(c>>5) & 0x3F
+	return RGBA{expand5(c >> 11), g<<2 | g>>4, expand5(c), 255}
+}
+
+// expand5 scales the low 5 bits of c to 8 bits, repeating the top bits in
+// the bottom ones so 0x1F becomes 255 rather than 248.
+func expand5(c uint16) uint8 {
+	v := uint8(c) & 0x1F
+	return v<<3 | v>>2
+}
+
 func RenderChunkedBitmap8bit(bmh BitmapHeader, cbd ChunkedBitmapData, palette Palette) []RGBA {
-	size := int(cbd.ChunksHeader.Width) * int(cbd.ChunksHeader.Height) * int(CHUNK_WIDTH) * int(CHUNK_HEIGHT)
-	result := make([]RGBA, size)
+	key, keyed := transparentKey(bmh)
+	return renderChunks(cbd, func(chunk *Chunk, dPos int) RGBA {
+		d := chunk.DecompData[dPos]
+		c := palette.Colors[d]
+		if keyed && uint32(d) == key {
+			c.A = 0
+		}
+		return c
+	})
+}
+
+func RenderChunkedBitmap15bit(bmh BitmapHeader, cbd ChunkedBitmapData) []RGBA {
+	return renderChunkedWords(bmh, cbd, RGB555)
+}
+
+func RenderChunkedBitmap16bit(bmh BitmapHeader, cbd ChunkedBitmapData) []RGBA {
+	return renderChunkedWords(bmh, cbd, RGB565)
+}
+
+func renderChunkedWords(bmh BitmapHeader, cbd ChunkedBitmapData, toRGBA func(uint16) RGBA) []RGBA {
+	key, keyed := transparentKey(bmh)
+	mask := keyMask(bmh)
+	return renderChunks(cbd, func(chunk *Chunk, dPos int) RGBA {
+		d := binary.LittleEndian.Uint16(chunk.DecompData[dPos*2 : dPos*2+2])
+		c := toRGBA(d)
+		if keyed && uint32(d)&mask == key&mask {
+			c.A = 0
+		}
+		return c
+	})
+}
+
+// renderChunks lays the chunks out on a grid, getting each pixel from
+// pixel(chunk, position in the chunk).
+func renderChunks[T any](cbd ChunkedBitmapData, pixel func(chunk *Chunk, dPos int) T) []T {
+	cw, ch := cbd.Format.Width, cbd.Format.Height
+	stride := int(cbd.ChunksHeader.Width) * cw
+	result := make([]T, stride*int(cbd.ChunksHeader.Height)*ch)
 
 	for i := range cbd.Chunks {
-		chunk := cbd.Chunks[i]
-		for k := 0; k < CHUNK_HEIGHT; k++ {
-			for l := 0; l < CHUNK_WIDTH; l++ {
-				xOff := (i % int(cbd.ChunksHeader.Width)) * CHUNK_WIDTH
-				yOff := (i / int(cbd.ChunksHeader.Width)) * CHUNK_HEIGHT
-				ri := k*CHUNK_HEIGHT*int(cbd.ChunksHeader.Width) + yOff*CHUNK_HEIGHT*int(cbd.ChunksHeader.Width) + l + xOff
-
-				dPos := k*64 + l
-				d := chunk.DecompData[dPos]
-				c := palette.Colors[d]
-				result[ri] = RGBA{c.R, c.G, c.B, c.A}
+		chunk := &cbd.Chunks[i]
+		xOff := (i % int(cbd.ChunksHeader.Width)) * cw
+		yOff := (i / int(cbd.ChunksHeader.Width)) * ch
+		for k := 0; k < ch; k++ {
+			for l := 0; l < cw; l++ {
+				result[(yOff+k)*stride+xOff+l] = pixel(chunk, k*cw+l)
 			}
 		}
 	}
@@ -0,0 +1,355 @@
+package graphics
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"slices"
+	"testing"
+)
+
+func TestNewBitmapTruecolor(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags BitmapFlagSet
+		data  []byte
+		want  []RGBA
+	}{
+		{
+			name:  "24 bit is stored BGR",
+			flags: BM_24BIT,
+			data:  []byte{0x00, 0x00, 0xFF, 0x00, 0xFF, 0x00, 0xFF, 0x00, 0x00, 0x10, 0x20, 0x30},
+			want:  []RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {0x30, 0x20, 0x10, 255}},
+		},
+		{
+			name:  "32 bit is stored BGRA",
+			flags: BM_32BIT,
+			data:  []byte{0x00, 0x00, 0xFF, 0xFF, 0x00, 0xFF, 0x00, 0x80, 0xFF, 0x00, 0x00, 0x00, 0x10, 0x20, 0x30, 0x40},
+			want:  []RGBA{{255, 0, 0, 255}, {0, 255, 0, 128}, {0, 0, 255, 0}, {0x30, 0x20, 0x10, 0x40}},
+		},
+		{
+			name:  "32 bit without alpha is opaque",
+			flags: BM_32BIT,
+			data:  []byte{0x00, 0x00, 0xFF, 0x00, 0x00, 0xFF, 0x00, 0x00, 0xFF, 0x00, 0x00, 0x00, 0x10, 0x20, 0x30, 0x00},
+			want:  []RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {0x30, 0x20, 0x10, 255}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := synthBitmap(BitmapHeader{Width: 2, Height: 2, Flags: tt.flags}, tt.data, nil)
+			bm, err := NewBitmapFromBytes(data, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bm.Width != 2 || bm.Height != 2 {
+				t.Errorf("size = %dx%d, want 2x2", bm.Width, bm.Height)
+			}
+			if !slices.Equal(bm.Data, tt.want) {
+				t.Errorf("Data = %v, want %v", bm.Data, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewBitmapTruecolorTruncated(t *testing.T) {
+	data := synthBitmap(BitmapHeader{Width: 2, Height: 2, Flags: BM_24BIT}, make([]byte, 11), nil)
+	_, err := NewBitmapFromBytes(data, false)
+
+	var te *ErrTruncated
+	if !errors.As(err, &te) || te.Want != 12 || te.Got != 11 {
+		t.Errorf("err = %v, want truncated 12 bytes, got 11", err)
+	}
+}
+
+func TestNewBitmapAlphaBuffer(t *testing.T) {
+	pixels := []byte{0x00, 0x00, 0xFF, 0x00, 0xFF, 0x00, 0xFF, 0x00, 0x00}
+	alpha := []byte{0, 128, 255}
+	bmh := BitmapHeader{Width: 3, Height: 1, Flags: BM_24BIT | BM_ALPHA, AlphaSize: 3}
+	// The alpha buffer follows the pixel data, relative to the Alpha field
+	bmh.Alpha = uint32(BMH_SIZE + len(pixels) - BMH_ALPHA_POS)
+
+	bm, err := NewBitmapFromBytes(synthBitmap(bmh, pixels, alpha), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(bm.Alpha, alpha) {
+		t.Errorf("Alpha = %v, want %v", bm.Alpha, alpha)
+	}
+	want := []RGBA{{255, 0, 0, 0}, {0, 255, 0, 128}, {0, 0, 255, 255}}
+	if !slices.Equal(bm.Data, want) {
+		t.Errorf("Data = %v, want %v", bm.Data, want)
+	}
+}
+
+func TestNewBitmapKeyColor(t *testing.T) {
+	tests := []struct {
+		name  string
+		bmh   BitmapHeader
+		data  []byte
+		want  []uint8
+		blend BlendMode
+	}{
+		{
+			name:  "8 bit keyed like bread.i2d",
+			bmh:   BitmapHeader{Width: 3, Height: 1, Flags: BM_8BIT, DrawingMode: DM_TRANSPARENT},
+			data:  []byte{0, 1, 0},
+			want:  []uint8{0, 255, 0},
+			blend: BLEND_KEYED,
+		},
+		{
+			name:  "8 bit without DM_TRANSPARENT",
+			bmh:   BitmapHeader{Width: 3, Height: 1, Flags: BM_8BIT},
+			data:  []byte{0, 1, 0},
+			want:  []uint8{255, 255, 255},
+			blend: BLEND_OPAQUE,
+		},
+		{
+			name:  "15 bit is opaque except the key",
+			bmh:   BitmapHeader{Width: 2, Height: 1, Flags: BM_15BIT, DrawingMode: DM_TRANSPARENT, KeyColor: 0x7C1F},
+			data:  []byte{0x1F, 0x7C, 0xFF, 0xFF},
+			want:  []uint8{0, 255},
+			blend: BLEND_KEYED,
+		},
+		{
+			name:  "15 bit key ignores the top bit",
+			bmh:   BitmapHeader{Width: 2, Height: 1, Flags: BM_15BIT, DrawingMode: DM_TRANSPARENT, KeyColor: 0x7C1F},
+			data:  []byte{0x1F, 0xFC, 0x1F, 0x7C},
+			want:  []uint8{0, 0},
+			blend: BLEND_KEYED,
+		},
+		{
+			name:  "16 bit keyed",
+			bmh:   BitmapHeader{Width: 2, Height: 1, Flags: BM_16BIT, DrawingMode: DM_TRANSPARENT, KeyColor: 0xF81F},
+			data:  []byte{0x1F, 0xF8, 0x00, 0x00},
+			want:  []uint8{0, 255},
+			blend: BLEND_KEYED,
+		},
+		{
+			name:  "16 bit key compares every bit",
+			bmh:   BitmapHeader{Width: 2, Height: 1, Flags: BM_16BIT, DrawingMode: DM_TRANSPARENT, KeyColor: 0x781F},
+			data:  []byte{0x1F, 0xF8, 0x1F, 0x78},
+			want:  []uint8{255, 0},
+			blend: BLEND_KEYED,
+		},
+		{
+			name:  "32 bit keyed without alpha",
+			bmh:   BitmapHeader{Width: 2, Height: 1, Flags: BM_32BIT, DrawingMode: DM_TRANSPARENT, KeyColor: 0xFF00FF},
+			data:  []byte{0xFF, 0x00, 0xFF, 0x00, 0xFF, 0xFF, 0x00, 0x00},
+			want:  []uint8{0, 255},
+			blend: BLEND_ALPHA,
+		},
+		{
+			name:  "32 bit keyed with alpha",
+			bmh:   BitmapHeader{Width: 2, Height: 1, Flags: BM_32BIT, DrawingMode: DM_TRANSPARENT, KeyColor: 0xFF00FF},
+			data:  []byte{0xFF, 0x00, 0xFF, 0xFF, 0xFF, 0xFF, 0x00, 0x80},
+			want:  []uint8{0, 0x80},
+			blend: BLEND_ALPHA,
+		},
+		{
+			name:  "24 bit keyed",
+			bmh:   BitmapHeader{Width: 2, Height: 1, Flags: BM_24BIT, DrawingMode: DM_TRANSPARENT | DM_TRANSLUCENT, KeyColor: 0xFF00FF},
+			data:  []byte{0xFF, 0x00, 0xFF, 0xFF, 0xFF, 0x00},
+			want:  []uint8{0, 255},
+			blend: BLEND_TRANSLUCENT,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trailer := []byte(nil)
+			if tt.bmh.Flags&BM_8BIT != 0 {
+				trailer = synthPalette()
+			}
+			bm, err := NewBitmapFromBytes(synthBitmap(tt.bmh, tt.data, trailer), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			alpha := []uint8{}
+			for _, c := range bm.Data {
+				alpha = append(alpha, c.A)
+			}
+			if !slices.Equal(alpha, tt.want) {
+				t.Errorf("alpha = %v, want %v", alpha, tt.want)
+			}
+			if bm.Blend != tt.blend {
+				t.Errorf("Blend = %v, want %v", bm.Blend, tt.blend)
+			}
+		})
+	}
+}
+
+func TestNewBitmapCompressedHighColor(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags BitmapFlagSet
+		want  RGBA
+	}{
+		{"15 bit", BM_15BIT | BM_COMPRESSED | BM_CHUNKED, RGBA{0, 255, 0, 255}},
+		{"16 bit", BM_16BIT | BM_COMPRESSED | BM_CHUNKED, RGBA{0, 125, 0, 255}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := synthChunked(1, 1, synthFilledChunkWords(0x03E0))
+			bm, err := NewBitmapFromBytes(synthBitmap(BitmapHeader{Width: 64, Height: 64, Flags: tt.flags}, data, nil), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(bm.Data) != CHUNK_WIDTH*CHUNK_HEIGHT || bm.Data[0] != tt.want || bm.Data[len(bm.Data)-1] != tt.want {
+				t.Errorf("Data[0] = %v, want %d pixels of %v", bm.Data[0], CHUNK_WIDTH*CHUNK_HEIGHT, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewBitmapChunkedCrop(t *testing.T) {
+	// 70x3 needs a 2x1 grid of chunks, 128x64 pixels
+	data := synthChunked(2, 1, synthFilledChunk(7), synthFilledChunk(9))
+	bmh := BitmapHeader{Width: 70, Height: 3, RegPointX: 35, RegPointY: 2, Flags: BM_8BIT | BM_COMPRESSED | BM_REGPOINT | BM_CHUNKED}
+
+	bm, err := NewBitmapFromBytes(synthBitmap(bmh, data, synthPalette()), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bm.Width != 70 || bm.Height != 3 || len(bm.Data) != 70*3 {
+		t.Fatalf("size = %dx%d with %d pixels, want 70x3", bm.Width, bm.Height, len(bm.Data))
+	}
+	if bm.Canvas != image.Rect(0, 0, 128, 64) || bm.Content != image.Rect(0, 0, 70, 3) {
+		t.Errorf("Canvas = %v, Content = %v, want (0,0)-(128,64) and (0,0)-(70,3)", bm.Canvas, bm.Content)
+	}
+	if bm.RegPoint != image.Pt(35, 2) {
+		t.Errorf("RegPoint = %v, want (35,2)", bm.RegPoint)
+	}
+
+	// Second row, across the chunk boundary
+	if bm.Data[70+63] != bm.Palette.Colors[7] || bm.Data[70+64] != bm.Palette.Colors[9] {
+		t.Errorf("pixels around the chunk boundary = %v %v", bm.Data[70+63], bm.Data[70+64])
+	}
+}
+
+func TestNewBitmapChunkedCoverage(t *testing.T) {
+	// A skip at the start of every row of the first chunk, the second one empty
+	rows := [][]byte{}
+	for range CHUNK_HEIGHT {
+		rows = append(rows, []byte{synthRle, 0x80 | 2, synthRle, CHUNK_WIDTH - 2, 0})
+	}
+	data := synthChunked(2, 1, synthChunk(rows...), nil)
+	bmh := BitmapHeader{Width: 128, Height: 1, Flags: BM_8BIT | BM_COMPRESSED | BM_CHUNKED}
+
+	bm, err := NewBitmapFromBytes(synthBitmap(bmh, data, synthPalette()), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Index 0 is black in the palette, only the coverage tells it apart
+	for x, c := range bm.Data {
+		covered := x >= 2 && x < CHUNK_WIDTH
+		if bm.Coverage[x] != covered || (c.A == 255) != covered {
+			t.Errorf("pixel %d: covered %v alpha %d, want covered %v", x, bm.Coverage[x], c.A, covered)
+		}
+	}
+}
+
+func TestReadBitmapBudget(t *testing.T) {
+	// A 1x1 bitmap decoded as a whole 64x64 chunk
+	data := synthBitmap(BitmapHeader{Width: 1, Height: 1, Flags: BM_8BIT | BM_COMPRESSED | BM_CHUNKED},
+		synthChunked(1, 1, synthFilledChunk(7)), synthPalette())
+	file := bytes.NewReader(data)
+
+	budget := CHUNK_WIDTH*CHUNK_HEIGHT - 1
+	bm, err := readBitmap(file, 0, "bitmap", false, &budget)
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("err = %v, want %v", err, ErrTooLarge)
+	}
+	if len(bm.Data) != 0 || budget != CHUNK_WIDTH*CHUNK_HEIGHT-1 {
+		t.Errorf("decoded %d pixels and left %d of the budget, want none decoded or taken", len(bm.Data), budget)
+	}
+
+	budget = CHUNK_WIDTH*CHUNK_HEIGHT + 1
+	bm, err = readBitmap(file, 0, "bitmap", false, &budget)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bm.Data) != 1 || budget != 1 {
+		t.Errorf("decoded %d pixels and left %d of the budget, want 1 and 1", len(bm.Data), budget)
+	}
+}
+
+func TestNewBitmapBudget(t *testing.T) {
+	// 4096x4096 in a few KB: every row is just an end of line code
+	rows := make([][]byte, MAX_BITMAP_DIMENSION)
+	data := synthBitmap(BitmapHeader{Width: MAX_BITMAP_DIMENSION, Height: MAX_BITMAP_DIMENSION, Flags: BM_8BIT | BM_COMPRESSED},
+		synthChunk(rows...), synthPalette())
+
+	bm, err := NewBitmapFromBytes(data, false)
+	if !errors.Is(err, ErrTooLarge) || len(bm.Data) != 0 {
+		t.Errorf("err = %v with %d pixels, want %v and none", err, len(bm.Data), ErrTooLarge)
+	}
+}
+
+func TestRGB555(t *testing.T) {
+	tests := []struct {
+		c    uint16
+		want RGBA
+	}{
+		{0x0000, RGBA{0, 0, 0, 255}},
+		{0x7FFF, RGBA{255, 255, 255, 255}},
+		{0xFFFF, RGBA{255, 255, 255, 255}}, // The top bit isn't alpha
+		{0x7C00, RGBA{255, 0, 0, 255}},
+		{0x03E0, RGBA{0, 255, 0, 255}},
+		{0x001F, RGBA{0, 0, 255, 255}},
+		{0x0421, RGBA{8, 8, 8, 255}},
+		{0x4210, RGBA{132, 132, 132, 255}},
+	}
+	for _, tt := range tests {
+		if got := RGB555(tt.c); got != tt.want {
+			t.Errorf("RGB555(%#04x) = %v, want %v", tt.c, got, tt.want)
+		}
+	}
+}
+
+func TestRGB565(t *testing.T) {
+	tests := []struct {
+		c    uint16
+		want RGBA
+	}{
+		{0x0000, RGBA{0, 0, 0, 255}},
+		{0xFFFF, RGBA{255, 255, 255, 255}},
+		{0xF800, RGBA{255, 0, 0, 255}},
+		{0x07E0, RGBA{0, 255, 0, 255}},
+		{0x001F, RGBA{0, 0, 255, 255}},
+		{0x0841, RGBA{8, 8, 8, 255}},
+		{0x0020, RGBA{0, 4, 0, 255}},
+		{0x8410, RGBA{132, 130, 132, 255}},
+	}
+	for _, tt := range tests {
+		if got := RGB565(tt.c); got != tt.want {
+			t.Errorf("RGB565(%#04x) = %v, want %v", tt.c, got, tt.want)
+		}
+	}
+}
+
+func TestNewBitmapHighColorPixels(t *testing.T) {
+	// White, red, green and blue
+	want := []RGBA{{255, 255, 255, 255}, {255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}}
+	tests := []struct {
+		name  string
+		flags BitmapFlagSet
+		words []byte
+	}{
+		{"15 bit", BM_15BIT, []byte{0xFF, 0x7F, 0x00, 0x7C, 0xE0, 0x03, 0x1F, 0x00}},
+		{"16 bit", BM_16BIT, []byte{0xFF, 0xFF, 0x00, 0xF8, 0xE0, 0x07, 0x1F, 0x00}},
+	}
+	for _, tt := range tests {
+		bm, err := NewBitmapFromBytes(synthBitmap(BitmapHeader{Width: 2, Height: 2, Flags: tt.flags}, tt.words, nil), false)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !slices.Equal(bm.Data, want) {
+			t.Errorf("%s: Data = %v, want %v", tt.name, bm.Data, want)
+		}
+	}
+}
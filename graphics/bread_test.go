@@ -0,0 +1,173 @@
+package graphics
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// The first 0x11F8 bytes of the game's bread.i2d, rebuilt from the values
+// in docs/bread_i2d_extracted_data.yml: the file resource header, the
+// imagery header, the bitmap table and the first bitmap with its palette.
+// The yml doesn't cover 0x70 to 0x570 beyond two offsets, so the rest of
+// that gap is zero, nor the second bitmap, so the data stops before it.
+const (
+	BREAD_BITMAP_OFFSET = 0x570
+	BREAD_SIZE          = 0x11F8
+)
+
+// readBreadValues parses the yml: "key: value" lines, and lists as a
+// "key: " line followed by "    - value" lines.
+func readBreadValues(t *testing.T) map[string][]uint32 {
+	t.Helper()
+
+	f, err := os.Open("../docs/bread_i2d_extracted_data.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	values := map[string][]uint32{}
+	key := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line == "---" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		value := ""
+		if item, ok := strings.CutPrefix(line, "- "); ok {
+			value = item
+		} else {
+			k, v, _ := strings.Cut(line, ":")
+			key, value = k, strings.TrimSpace(v)
+			if value == "" {
+				values[key] = []uint32{}
+				continue
+			}
+		}
+
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+		values[key] = append(values[key], uint32(n))
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+// breadI2D returns the rebuilt bytes of bread.i2d.
+func breadI2D(t *testing.T) []byte {
+	t.Helper()
+
+	values := readBreadValues(t)
+	get := func(key string) []uint32 {
+		v, ok := values[key]
+		if !ok {
+			t.Fatalf("bread.i2d: no %s", key)
+		}
+		return v
+	}
+
+	le := binary.LittleEndian
+	buf := []byte{}
+	u8 := func(keys ...string) {
+		for _, k := range keys {
+			for _, v := range get(k) {
+				buf = append(buf, byte(v))
+			}
+		}
+	}
+	u16 := func(keys ...string) {
+		for _, k := range keys {
+			for _, v := range get(k) {
+				buf = le.AppendUint16(buf, uint16(v))
+			}
+		}
+	}
+	u32 := func(keys ...string) {
+		for _, k := range keys {
+			for _, v := range get(k) {
+				buf = le.AppendUint32(buf, v)
+			}
+		}
+	}
+
+	u32("magic")
+	u16("topbm_num_bitmaps")
+	u8("compression_type", "version")
+	u32("datasize", "objsize", "hdrsize")
+
+	u32("imageryid", "numstates", "name", "walkmap", "imageryflags")
+	u16("anim_flags", "frames", "max_width", "max_height",
+		"registration_x", "registration_y", "registration_z",
+		"anim_reg_x", "anim_reg_y", "anim_reg_z",
+		"world_reg_x", "world_reg_y", "world_reg_z",
+		"world_width", "world_length", "world_height",
+		"inventory_anim_flags", "inventory_frames")
+	u32("bitmap1_offset", "bitmap2_offset")
+
+	gap := make([]byte, BREAD_BITMAP_OFFSET-len(buf))
+	le.PutUint32(gap[0:4], get("offset_from_here_to_2nd_bitmap")[0])
+	le.PutUint32(gap[8:12], get("offset_from_here_to_1st_bitmap")[0])
+	buf = append(buf, gap...)
+
+	u32("bitmap_width", "bitmap_height", "registration_point_x", "registration_point_y",
+		"bitmap_flags", "drawing_mode", "key_color",
+		"alias_size", "alias_offset", "alpha_size", "alpha_offset",
+		"zbuffer_size", "zbuffer_offset", "normal_size", "normal_offset",
+		"palettesize", "palette_offset_from_here", "bitmap_datasize")
+	u8("bitmap_data")
+	u16("palette")
+	u32("palette_rgb")
+
+	if len(buf) != BREAD_SIZE {
+		t.Fatalf("bread.i2d: rebuilt %#x bytes, want %#x", len(buf), BREAD_SIZE)
+	}
+	return buf
+}
+
+func TestNewFileResourceBread(t *testing.T) {
+	data := breadI2D(t)
+	values := readBreadValues(t)
+
+	fr, err := NewFileResourceFromBytes(data, false)
+
+	// The second bitmap isn't part of the rebuilt data
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Op != "bitmap 1 header" || pe.Offset != BREAD_SIZE {
+		t.Errorf("err = %v, want a bitmap 1 header *ParseError at %#x", err, BREAD_SIZE)
+	}
+
+	if len(fr.BitmapTable) != 2 || len(fr.Bitmaps) != 2 {
+		t.Fatalf("got %d offsets and %d bitmaps, want 2 of each", len(fr.BitmapTable), len(fr.Bitmaps))
+	}
+
+	bm := fr.Bitmaps[0]
+	if bm.Width != 40 || bm.Height != 40 || len(bm.Data) != 40*40 {
+		t.Fatalf("bitmap 0 is %dx%d with %d pixels, want 40x40", bm.Width, bm.Height, len(bm.Data))
+	}
+	if bm.Blend != BLEND_KEYED {
+		t.Errorf("Blend = %v, want %v", bm.Blend, BLEND_KEYED)
+	}
+
+	rgb := values["palette_rgb"]
+	for i, idx := range values["bitmap_data"] {
+		c := rgb[idx]
+		want := RGBA{byte(c), byte(c >> 8), byte(c >> 16), 255}
+		if idx == 0 {
+			want.A = 0 // Key color
+		}
+		if bm.Data[i] != want {
+			t.Fatalf("pixel %d (index %d) = %v, want %v", i, idx, bm.Data[i], want)
+		}
+	}
+}
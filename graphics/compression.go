@@ -2,13 +2,41 @@ package graphics
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/depy/RevenantRE/utils"
+)
+
+const (
+	CHUNK_HEIGHT      = 64
+	CHUNK_WIDTH       = 64
+	CHUNK_PREFIX_SIZE = 6 // Chunk id, 3 unknown bytes, RLE marker, LZ marker
 )
 
+// Chunk types (ChunksHeader.Type)
 const (
-	CHUNK_HEIGHT = 64
-	CHUNK_WIDTH  = 64
+	CHUNK_TYPE_RLE_LZ uint32 = 0 // CHUNK_WIDTH x CHUNK_HEIGHT chunks with a CHUNK_PREFIX_SIZE prefix, see decode
 )
 
+// ChunkFormat is the layout the chunks of a bitmap are decoded with.
+type ChunkFormat struct {
+	Width         int
+	Height        int
+	BytesPerPixel int // From the bitmap flags, 1 or 2
+}
+
+// chunkFormat returns the layout of chunks of type chunkType. Every chunked
+// bitmap seen so far has CHUNK_TYPE_RLE_LZ, so ok is false for any other.
+func chunkFormat(chunkType uint32, bytesPerPixel int) (format ChunkFormat, ok bool) {
+	switch chunkType {
+	case CHUNK_TYPE_RLE_LZ:
+		return ChunkFormat{CHUNK_WIDTH, CHUNK_HEIGHT, bytesPerPixel}, true
+	}
+	return ChunkFormat{}, false
+}
+
 type ChunksHeader struct {
 	Type    uint32
 	Width   uint32
@@ -20,11 +48,13 @@ type Chunk struct {
 	ChunkId    byte
 	RleMarker  byte
 	LzMarker   byte
-	DecompData []byte
+	DecompData []byte // Format.Width*Format.Height pixels, 16 bit ones little endian
+	Covered    []bool // Pixels the chunk draws. Skipped ones, and all of an empty chunk's, are transparent
 }
 
 type ChunkedBitmapData struct {
 	ChunksHeader ChunksHeader
+	Format       ChunkFormat
 	Chunks       []Chunk
 }
 
@@ -39,54 +69,160 @@ func PrintChunksHeader(ch ChunksHeader) {
 	println("\n--------------------------")
 }
 
-func NewChunksHeader(data []byte) ChunksHeader {
+func NewChunksHeader(data []byte) (ChunksHeader, error) {
+	if err := utils.CheckSize(data, 12); err != nil {
+		return ChunksHeader{}, err
+	}
+
 	ch := ChunksHeader{}
 	ch.Type = binary.LittleEndian.Uint32(data[0:4])
 	ch.Width = binary.LittleEndian.Uint32(data[4:8])
 	ch.Height = binary.LittleEndian.Uint32(data[8:12])
 
+	count := uint64(ch.Width) * uint64(ch.Height)
+	if uint64(len(data)-12)/4 < count {
+		return ChunksHeader{}, &utils.ErrTruncated{Offset: 12, Want: int(min(count*4, math.MaxInt)), Got: len(data) - 12}
+	}
+
 	for i := range ch.Width * ch.Height {
 		chunkOffset := binary.LittleEndian.Uint32(data[12+i*4 : 12+i*4+4]) // 12 is for previously read 3 field (3 * 4)
 		ch.Offsets = append(ch.Offsets, chunkOffset)
 	}
-	return ch
+	return ch, nil
 }
 
-func Decompress(data []byte, isChunked bool) ChunkedBitmapData {
-	if isChunked {
+func Decompress(data []byte, isChunked bool, bytesPerPixel int) (ChunkedBitmapData, error) {
+	if !isChunked {
+		return ChunkedBitmapData{}, fmt.Errorf("%w: compressed bitmap that isn't chunked, see DecompressPlain", ErrUnsupportedFlags)
+	}
+
+	switch bytesPerPixel {
+	case 1:
 		return DecompressChunked(data)
-	} else {
-		return ChunkedBitmapData{}
+	case 2:
+		return DecompressChunkedWords(data)
+	default:
+		return ChunkedBitmapData{}, fmt.Errorf("%w: compressed bitmap with %d bytes per pixel", ErrUnsupportedFlags, bytesPerPixel)
 	}
 }
 
-func DecompressChunked(data []byte) ChunkedBitmapData {
-	h := NewChunksHeader(data)
+// DecompressChunked decodes every chunk of a chunked 8 bit bitmap. Corrupt
+// chunks don't stop the others from decoding: they are kept as far as they
+// got, and a *ChunkError for each of them is returned, joined together.
+// An unknown ChunksHeader.Type returns just the header and an error
+// wrapping ErrUnknownChunkType.
+func DecompressChunked(data []byte) (ChunkedBitmapData, error) {
+	return decompressChunked(data, decode, 1)
+}
+
+// DecompressChunkedWords decodes a chunked 15 or 16 bit bitmap, see
+// decodeWords for why its output is unverified.
+func DecompressChunkedWords(data []byte) (ChunkedBitmapData, error) {
+	return decompressChunked(data, decodeWords, 2)
+}
+
+func decompressChunked(data []byte, decode func([]byte, int, int) (Chunk, int, error), bytesPerPixel int) (ChunkedBitmapData, error) {
+	h, err := NewChunksHeader(data)
+	if err != nil {
+		return ChunkedBitmapData{}, err
+	}
+
+	format, ok := chunkFormat(h.Type, bytesPerPixel)
+	if !ok {
+		return ChunkedBitmapData{ChunksHeader: h}, fmt.Errorf("%w: %d", ErrUnknownChunkType, h.Type)
+	}
 
-	cbd := ChunkedBitmapData{ChunksHeader: h}
+	if uint64(h.Width)*uint64(format.Width) > MAX_BITMAP_DIMENSION || uint64(h.Height)*uint64(format.Height) > MAX_BITMAP_DIMENSION {
+		return ChunkedBitmapData{}, fmt.Errorf("%w: %dx%d chunks", ErrTooLarge, h.Width, h.Height)
+	}
+
+	errs := []error{}
+	cbd := ChunkedBitmapData{ChunksHeader: h, Format: format}
+	chunkSize := format.Width * format.Height * bytesPerPixel
 	cbd.Chunks = []Chunk{}
 	for i := range len(h.Offsets) {
 		if h.Offsets[i] != 0 {
 			chunkOffsetValueOffset := 12 + 4*i
 			ofs := chunkOffsetValueOffset + int(h.Offsets[i])
+			if ofs >= len(data) {
+				errs = append(errs, &ChunkError{i, ofs, fmt.Errorf("%w: chunk starts past the end of the data (%d bytes)", ErrCorruptChunk, len(data))})
+				cbd.Chunks = append(cbd.Chunks, Chunk{DecompData: make([]byte, chunkSize)})
+				continue
+			}
+
+			if len(data)-ofs < minCodedSize(format.Height, bytesPerPixel) {
+				errs = append(errs, &ChunkError{i, ofs, fmt.Errorf("%w: %d bytes can't hold %d rows", ErrCorruptChunk, len(data)-ofs, format.Height)})
+				cbd.Chunks = append(cbd.Chunks, Chunk{DecompData: make([]byte, chunkSize)})
+				continue
+			}
+
 			chunkStart := data[ofs:]
-			chunk := decode(chunkStart)
+			chunk, si, err := decode(chunkStart, format.Width, format.Height)
+			if err != nil {
+				errs = append(errs, &ChunkError{i, ofs + si, err})
+			}
 			cbd.Chunks = append(cbd.Chunks, chunk)
 		} else {
 			c := Chunk{}
-			c.DecompData = make([]byte, CHUNK_HEIGHT*CHUNK_WIDTH)
+			c.DecompData = make([]byte, chunkSize)
 			cbd.Chunks = append(cbd.Chunks, c)
 		}
 	}
-	return cbd
+	return cbd, errors.Join(errs...)
+}
+
+// DecompressPlain decodes a compressed bitmap that isn't chunked. The
+// whole bitmap is coded like a single width*height chunk: the same prefix,
+// then rows of codes each ending with an end of line code. Returns the
+// pixels, 16 bit ones little endian, and which of them are drawn (see
+// Chunk.Covered), decoded as far as it got on error, which is a
+// *ChunkError for chunk 0. Data too short to hold height rows is rejected
+// before anything is allocated.
+func DecompressPlain(data []byte, width uint32, height uint32, bytesPerPixel int) ([]byte, []bool, error) {
+	if width > MAX_BITMAP_DIMENSION || height > MAX_BITMAP_DIMENSION {
+		return nil, nil, fmt.Errorf("%w: %dx%d", ErrTooLarge, width, height)
+	}
+
+	var decodeChunk func([]byte, int, int) (Chunk, int, error)
+	switch bytesPerPixel {
+	case 1:
+		decodeChunk = decode
+	case 2:
+		decodeChunk = decodeWords
+	default:
+		return nil, nil, fmt.Errorf("%w: compressed bitmap with %d bytes per pixel", ErrUnsupportedFlags, bytesPerPixel)
+	}
+	if len(data) < minCodedSize(int(height), bytesPerPixel) {
+		return nil, nil, &ChunkError{0, 0, fmt.Errorf("%w: %d bytes can't hold %d rows", ErrCorruptChunk, len(data), height)}
+	}
+
+	chunk, si, err := decodeChunk(data, int(width), int(height))
+	if err != nil {
+		return chunk.DecompData, chunk.Covered, &ChunkError{0, si, err}
+	}
+	return chunk.DecompData, chunk.Covered, nil
+}
+
+// minCodedSize is the least data a chunk of height rows can be coded in:
+// the prefix, then an end of line code for every row. Checked before
+// decoding, so the pixels allocated are paid for with input.
+func minCodedSize(height int, bytesPerPixel int) int {
+	return CHUNK_PREFIX_SIZE + height*2*bytesPerPixel
 }
 
-func decode(data []byte) Chunk {
+// decode decompresses one width*height chunk. On error it returns the
+// chunk decoded so far and the offset in data of the byte it failed on.
+func decode(data []byte, width int, height int) (Chunk, int, error) {
+	if len(data) < CHUNK_PREFIX_SIZE {
+		return Chunk{DecompData: make([]byte, width*height), Covered: make([]bool, width*height)}, 0,
+			fmt.Errorf("%w: %d bytes is too short for the chunk prefix", ErrCorruptChunk, len(data))
+	}
+
 	chunkId := data[0]
 	// unknownValue := chunkStart[1:4]
 	rleMarker := data[4]
 	lzMarker := data[5]
-	compData := data[6:]
+	compData := data[CHUNK_PREFIX_SIZE:]
 
 	chunk := Chunk{
 		ChunkId:   chunkId,
@@ -94,16 +230,32 @@ func decode(data []byte) Chunk {
 		LzMarker:  lzMarker,
 	}
 
-	chunk.DecompData = make([]byte, CHUNK_HEIGHT*CHUNK_WIDTH)
+	chunk.DecompData = make([]byte, width*height)
+	chunk.Covered = make([]bool, width*height)
+	dst := chunk.DecompData
+	covered := chunk.Covered
 
 	si := 0 // Source index
 	di := 0 // Destination index
 	row := 0
-	for row < CHUNK_HEIGHT {
+
+	// fail reports an error at the start of the current code
+	fail := func(start int, format string, args ...any) (Chunk, int, error) {
+		return chunk, CHUNK_PREFIX_SIZE + start, fmt.Errorf("%w: row %d: %s", ErrCorruptChunk, row, fmt.Sprintf(format, args...))
+	}
+
+	for row < height {
+		start := si
+		if si >= len(compData) {
+			return fail(start, "data ends before the last row")
+		}
 		b := compData[si]
 		si++
 
 		if b == rleMarker {
+			if si >= len(compData) {
+				return fail(start, "data ends inside an RLE code")
+			}
 			count := compData[si]
 			si++
 
@@ -114,32 +266,167 @@ func decode(data []byte) Chunk {
 			} else if count < 0x80 {
 				// Normal RLE mode
 				count &= 0x7f
+				if si >= len(compData) {
+					return fail(start, "data ends inside an RLE code")
+				}
 				val := compData[si]
 				si++
+				if di+int(count) > len(dst) {
+					return fail(start, "RLE run of %d at pixel %d overflows the chunk", count, di)
+				}
 				for i := 0; i < int(count); i++ {
-					chunk.DecompData[di] = val
+					dst[di] = val
+					covered[di] = true
 					di++
 				}
 			} else if count >= 0x80 {
 				// Skip mode
 				count &= 0x7f
+				if di+int(count) > len(dst) {
+					return fail(start, "skip of %d at pixel %d overflows the chunk", count, di)
+				}
 				di += int(count)
 			}
 		} else if b == lzMarker {
+			if si+3 > len(compData) {
+				return fail(start, "data ends inside an LZ code")
+			}
 			count := compData[si]
 			si++
 			offset := binary.LittleEndian.Uint16(compData[si : si+2])
 			si += 2
 			lzOffset := di - int(offset) - 4
+			if lzOffset < 0 {
+				return fail(start, "LZ back-reference to pixel %d from pixel %d is before the start of the chunk", lzOffset, di)
+			}
+			if di+int(count) > len(dst) {
+				return fail(start, "LZ copy of %d at pixel %d overflows the chunk", count, di)
+			}
 			for i := 0; i < int(count); i++ {
-				chunk.DecompData[di] = chunk.DecompData[lzOffset]
+				dst[di] = dst[lzOffset]
+				covered[di] = covered[lzOffset]
+				di++
+				lzOffset++
+			}
+		} else {
+			if di >= len(dst) {
+				return fail(start, "literal at pixel %d overflows the chunk", di)
+			}
+			dst[di] = b
+			covered[di] = true
+			di++
+		}
+	}
+	return chunk, CHUNK_PREFIX_SIZE + si, nil
+}
+
+// decodeWords decompresses one 15 or 16 bit chunk. No census file is
+// compressed with 15 or 16 bit pixels, so the format is an unverified
+// guess: the same scheme as decode, but every code, count, offset and
+// pixel is a little endian 16 bit word, and the markers are the prefix
+// bytes compared against whole words.
+func decodeWords(data []byte, width int, height int) (Chunk, int, error) {
+	if len(data) < CHUNK_PREFIX_SIZE {
+		return Chunk{DecompData: make([]byte, width*height*2), Covered: make([]bool, width*height)}, 0,
+			fmt.Errorf("%w: %d bytes is too short for the chunk prefix", ErrCorruptChunk, len(data))
+	}
+
+	chunk := Chunk{
+		ChunkId:   data[0],
+		RleMarker: data[4],
+		LzMarker:  data[5],
+	}
+	rleMarker := uint16(chunk.RleMarker)
+	lzMarker := uint16(chunk.LzMarker)
+	compData := data[CHUNK_PREFIX_SIZE:]
+
+	chunk.DecompData = make([]byte, width*height*2)
+	chunk.Covered = make([]bool, width*height)
+	dst := chunk.DecompData
+	covered := chunk.Covered
+	pixels := width * height
+
+	si := 0 // Source index, in bytes
+	di := 0 // Destination index, in pixels
+	row := 0
+
+	fail := func(start int, format string, args ...any) (Chunk, int, error) {
+		return chunk, CHUNK_PREFIX_SIZE + start, fmt.Errorf("%w: row %d: %s", ErrCorruptChunk, row, fmt.Sprintf(format, args...))
+	}
+	next := func() (uint16, bool) {
+		if si+2 > len(compData) {
+			return 0, false
+		}
+		w := binary.LittleEndian.Uint16(compData[si : si+2])
+		si += 2
+		return w, true
+	}
+
+	for row < height {
+		start := si
+		w, ok := next()
+		if !ok {
+			return fail(start, "data ends before the last row")
+		}
+
+		if w == rleMarker {
+			count, ok := next()
+			if !ok {
+				return fail(start, "data ends inside an RLE code")
+			}
+
+			if count == 0 {
+				// End of line
+				row++
+			} else if count < 0x8000 {
+				// Normal RLE mode
+				val, ok := next()
+				if !ok {
+					return fail(start, "data ends inside an RLE code")
+				}
+				if di+int(count) > pixels {
+					return fail(start, "RLE run of %d at pixel %d overflows the chunk", count, di)
+				}
+				for range int(count) {
+					binary.LittleEndian.PutUint16(dst[di*2:], val)
+					covered[di] = true
+					di++
+				}
+			} else {
+				// Skip mode
+				count &= 0x7fff
+				if di+int(count) > pixels {
+					return fail(start, "skip of %d at pixel %d overflows the chunk", count, di)
+				}
+				di += int(count)
+			}
+		} else if w == lzMarker {
+			count, ok1 := next()
+			offset, ok2 := next()
+			if !ok1 || !ok2 {
+				return fail(start, "data ends inside an LZ code")
+			}
+			lzOffset := di - int(offset) - 4
+			if lzOffset < 0 {
+				return fail(start, "LZ back-reference to pixel %d from pixel %d is before the start of the chunk", lzOffset, di)
+			}
+			if di+int(count) > pixels {
+				return fail(start, "LZ copy of %d at pixel %d overflows the chunk", count, di)
+			}
+			for range int(count) {
+				copy(dst[di*2:di*2+2], dst[lzOffset*2:lzOffset*2+2])
+				covered[di] = covered[lzOffset]
 				di++
 				lzOffset++
 			}
 		} else {
-			chunk.DecompData[di] = b
+			if di >= pixels {
+				return fail(start, "literal at pixel %d overflows the chunk", di)
+			}
+			binary.LittleEndian.PutUint16(dst[di*2:], w)
+			covered[di] = true
 			di++
 		}
 	}
-	return chunk
+	return chunk, CHUNK_PREFIX_SIZE + si, nil
 }
@@ -0,0 +1,178 @@
+package graphics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestDecompressPlain(t *testing.T) {
+	// Like pb.i2d: 8 bit, compressed, not chunked
+	data := synthChunk(
+		[]byte{1, synthRle, 3, 2},
+		[]byte{synthRle, 0x80 | 1, synthLz, 3, 1, 0},
+	)
+	pixels, covered, err := DecompressPlain(data, 4, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{1, 2, 2, 2, 0, 1, 2, 2}
+	if !slices.Equal(pixels, want) {
+		t.Errorf("pixels = %v, want %v", pixels, want)
+	}
+	wantCovered := []bool{true, true, true, true, false, true, true, true}
+	if !slices.Equal(covered, wantCovered) {
+		t.Errorf("covered = %v, want %v", covered, wantCovered)
+	}
+
+	bm, err := NewBitmapFromBytes(synthBitmap(BitmapHeader{Width: 4, Height: 2, Flags: BM_8BIT | BM_COMPRESSED}, data, synthPalette()), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bm.Data) != 8 || bm.Data[1] != bm.Data[2] {
+		t.Errorf("Data = %v, want 8 pixels from the palette", bm.Data)
+	}
+	if bm.Data[4].A != 0 || bm.Data[5].A != 255 {
+		t.Errorf("alpha of the skipped pixel and the next = %d, %d, want 0, 255", bm.Data[4].A, bm.Data[5].A)
+	}
+}
+
+func TestDecompressPlainWords(t *testing.T) {
+	data := synthChunkWords([]uint16{0xF800, synthRle, 2, 0x001F})
+	pixels, _, err := DecompressPlain(data, 3, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x00, 0xF8, 0x1F, 0x00, 0x1F, 0x00}
+	if !slices.Equal(pixels, want) {
+		t.Errorf("pixels = %v, want %v", pixels, want)
+	}
+}
+
+func TestDecompressPlainCorrupt(t *testing.T) {
+	data := synthChunk([]byte{synthRle, 5, 1})
+	pixels, _, err := DecompressPlain(data, 4, 1, 1)
+
+	var ce *ChunkError
+	if !errors.As(err, &ce) || !errors.Is(err, ErrCorruptChunk) {
+		t.Fatalf("err = %v, want a corrupt chunk error", err)
+	}
+	if len(pixels) != 4 {
+		t.Errorf("len(pixels) = %d, want 4", len(pixels))
+	}
+}
+
+func TestDecompressPlainShort(t *testing.T) {
+	// Too short for an end of line code on every row
+	data := synthChunk(make([][]byte, 99)...)
+	pixels, covered, err := DecompressPlain(data, MAX_BITMAP_DIMENSION, 100, 1)
+
+	var ce *ChunkError
+	if !errors.As(err, &ce) || !errors.Is(err, ErrCorruptChunk) || pixels != nil || covered != nil {
+		t.Errorf("err = %v with %d pixels, want a corrupt chunk error before allocating", err, len(pixels))
+	}
+}
+
+func TestDecompressChunkedUnknownType(t *testing.T) {
+	data := synthChunked(1, 1, synthFilledChunk(7))
+	data[0] = 0x7F
+
+	cbd, err := DecompressChunked(data)
+	if !errors.Is(err, ErrUnknownChunkType) {
+		t.Errorf("err = %v, want ErrUnknownChunkType", err)
+	}
+	if len(cbd.Chunks) != 0 || cbd.ChunksHeader.Type != 0x7F {
+		t.Errorf("%d chunks decoded with type %#x, want just the header", len(cbd.Chunks), cbd.ChunksHeader.Type)
+	}
+}
+
+func TestNewFileResourceUnknownChunkType(t *testing.T) {
+	chunked := synthChunked(1, 1, synthFilledChunk(7))
+	chunked[0] = 0x7F
+	unknown := synthBitmap(BitmapHeader{Width: 2, Height: 1, Flags: BM_8BIT | BM_COMPRESSED | BM_CHUNKED}, chunked, synthPalette())
+	plain := synthBitmap(BitmapHeader{Width: 1, Height: 1, Flags: BM_24BIT}, []byte{1, 2, 3}, nil)
+	data := synthResource(unknown, plain)
+
+	fr, err := NewFileResourceFromBytes(data, false)
+	var pe *ParseError
+	if !errors.Is(err, ErrUnknownChunkType) || !errors.As(err, &pe) || pe.Op != "bitmap 0 data" {
+		t.Errorf("err = %v, want a bitmap 0 data *ParseError wrapping %v", err, ErrUnknownChunkType)
+	}
+	if len(fr.Bitmaps) != 2 || len(fr.Bitmaps[0].Data) != 0 || len(fr.Bitmaps[1].Data) != 1 {
+		t.Fatalf("want the header of the first bitmap and the second decoded, got %d bitmaps", len(fr.Bitmaps))
+	}
+	if fr.Bitmaps[0].ChunkType != 0x7F {
+		t.Errorf("ChunkType = %#x, want 0x7f", fr.Bitmaps[0].ChunkType)
+	}
+
+	// Headers only still records the type
+	fr, _ = NewFileResourceFromBytes(data, true)
+	if fr.Bitmaps[0].ChunkType != 0x7F || len(fr.Bitmaps[0].Data) != 0 {
+		t.Errorf("headers only: ChunkType = %#x with %d pixels, want 0x7f and none", fr.Bitmaps[0].ChunkType, len(fr.Bitmaps[0].Data))
+	}
+}
+
+func TestDecompressChunkedCorrupt(t *testing.T) {
+	// A literal 5 then RLE runs up to pixel 4065, 31 short of a full chunk
+	fill := []byte{5}
+	for range 32 {
+		fill = append(fill, synthRle, 0x7F, 9)
+	}
+	full := append(slices.Clone(fill), synthRle, 31, 9)
+
+	good := synthFilledChunk(7)
+	start := 12 + 4*2 + len(good) // Where the second chunk starts
+
+	tests := []struct {
+		name  string
+		codes []byte // Codes of the second chunk
+		code  int    // Offset of the bad code in codes
+	}{
+		{"LZ before the chunk start", []byte{5, synthLz, 1, 10, 0}, 1},
+		{"RLE overflow", append(slices.Clone(fill), synthRle, 0x7F, 9), len(fill)},
+		{"skip overflow", append(slices.Clone(fill), synthRle, 0x80|0x7F), len(fill)},
+		{"LZ overflow", append(slices.Clone(fill), synthLz, 0x7F, 0, 0), len(fill)},
+		{"literal overflow", append(slices.Clone(full), 5), len(full)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// End of line codes for every row, so only the bad code is wrong
+			codes := append(slices.Clone(tt.codes), bytes.Repeat([]byte{synthRle, 0}, CHUNK_HEIGHT)...)
+			cbd, err := DecompressChunked(synthChunked(2, 1, good, synthChunk(codes)))
+
+			var ce *ChunkError
+			if !errors.As(err, &ce) || !errors.Is(err, ErrCorruptChunk) {
+				t.Fatalf("err = %v, want a corrupt chunk error", err)
+			}
+			if want := start + CHUNK_PREFIX_SIZE + tt.code; ce.Chunk != 1 || ce.Offset != want {
+				t.Errorf("error in chunk %d at %d, want chunk 1 at %d", ce.Chunk, ce.Offset, want)
+			}
+			if len(cbd.Chunks) != 2 || cbd.Chunks[0].DecompData[0] != 7 {
+				t.Fatalf("want both chunks, the first decoded")
+			}
+			c := cbd.Chunks[1]
+			if len(c.DecompData) != CHUNK_WIDTH*CHUNK_HEIGHT || c.DecompData[0] != 5 || !c.Covered[0] {
+				t.Errorf("second chunk has %d pixels starting %v, want the partly decoded chunk", len(c.DecompData), c.DecompData[:1])
+			}
+		})
+	}
+
+	t.Run("offset past the end", func(t *testing.T) {
+		data := synthChunked(2, 1, good, nil)
+		binary.LittleEndian.PutUint32(data[16:20], uint32(len(data)))
+		cbd, err := DecompressChunked(data)
+
+		var ce *ChunkError
+		if !errors.As(err, &ce) || !errors.Is(err, ErrCorruptChunk) {
+			t.Fatalf("err = %v, want a corrupt chunk error", err)
+		}
+		if want := 16 + len(data); ce.Chunk != 1 || ce.Offset != want {
+			t.Errorf("error in chunk %d at %d, want chunk 1 at %d", ce.Chunk, ce.Offset, want)
+		}
+		if len(cbd.Chunks) != 2 || cbd.Chunks[0].DecompData[0] != 7 || len(cbd.Chunks[1].DecompData) != CHUNK_WIDTH*CHUNK_HEIGHT {
+			t.Errorf("want the first chunk decoded and an empty second one")
+		}
+	})
+}
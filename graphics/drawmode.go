@@ -0,0 +1,74 @@
+package graphics
+
+// DrawingMode is how the game blits a bitmap (BitmapHeader.DrawingMode).
+// bread.i2d has 256, DM_TRANSPARENT, along with key color 0, its black
+// background. The other bits follow the order of the engine's DM_* draw
+// flags and haven't been confirmed on the game files yet.
+type DrawingMode uint32
+
+const (
+	DM_NOCLIP      DrawingMode = 0x0001 // Don't clip to the destination
+	DM_WRAPCLIP    DrawingMode = 0x0002 // Wrap around the destination edges
+	DM_WRAPCLIPSRC DrawingMode = 0x0004 // Wrap around the source edges
+	DM_STRETCH     DrawingMode = 0x0008 // Stretch to the destination rect
+	DM_BACKGROUND  DrawingMode = 0x0010 // Drawn into the background
+	DM_NORESTORE   DrawingMode = 0x0020 // Background isn't restored
+	DM_REVERSEVERT DrawingMode = 0x0040 // Flipped vertically
+	DM_REVERSEHORZ DrawingMode = 0x0080 // Flipped horizontally
+	DM_TRANSPARENT DrawingMode = 0x0100 // Pixels of KeyColor aren't drawn
+	DM_ZMASK       DrawingMode = 0x0200 // Masked by the Z buffer
+	DM_ZBUFFER     DrawingMode = 0x0400 // Drawn with its own Z buffer
+	DM_NORMALS     DrawingMode = 0x0800 // Lit through its normal buffer
+	DM_TRANSLUCENT DrawingMode = 0x1000 // Blended 50/50 with the destination
+)
+
+// Has reports whether every mode in modes is set.
+func (m DrawingMode) Has(modes DrawingMode) bool {
+	return m&modes == modes
+}
+
+// BlendMode is how a compositor should combine a decoded bitmap with what's
+// behind it. Pixel alpha already accounts for the key color and the alpha
+// buffer; translucency is left to the compositor. The engine also has additive
+// and shadow blits, but which drawing mode bits select them hasn't been
+// found yet, so there are no modes for them.
+type BlendMode int
+
+const (
+	BLEND_OPAQUE      BlendMode = iota // Every pixel covers the background
+	BLEND_KEYED                        // Pixels of the key color have alpha 0
+	BLEND_ALPHA                        // Pixels have alpha from the alpha buffer or 32 bit data
+	BLEND_TRANSLUCENT                  // Drawn at half opacity over the background
+)
+
+// NewBlendMode picks the blend mode for a bitmap from its drawing mode and
+// flags.
+func NewBlendMode(bmh BitmapHeader) BlendMode {
+	dm := bmh.DrawingMode
+	flags := NewBitmapFlags(bmh.Flags)
+	switch {
+	case dm.Has(DM_TRANSLUCENT):
+		return BLEND_TRANSLUCENT
+	case flags.HasAlpha || flags.Is32bit:
+		return BLEND_ALPHA
+	case dm.Has(DM_TRANSPARENT):
+		return BLEND_KEYED
+	}
+	return BLEND_OPAQUE
+}
+
+// transparentKey returns the key color in the bitmap's own pixel format
+// (palette index, 15/16 bit word or 0xRRGGBB) if it has color-key
+// transparency.
+func transparentKey(bmh BitmapHeader) (uint32, bool) {
+	return bmh.KeyColor, bmh.DrawingMode.Has(DM_TRANSPARENT)
+}
+
+// keyMask is the bits of a pixel compared against the key color. The top
+// bit of a 15 bit pixel isn't part of its color, see RGB555.
+func keyMask(bmh BitmapHeader) uint32 {
+	if bmh.Flags&BM_15BIT != 0 {
+		return 0x7FFF
+	}
+	return 0xFFFFFFFF
+}
@@ -0,0 +1,53 @@
+package graphics
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/depy/RevenantRE/utils"
+)
+
+var (
+	ErrBadMagic               = errors.New("bad magic, not a resource file")
+	ErrBadVersion             = errors.New("unsupported resource version")
+	ErrUnsupportedCompression = errors.New("unsupported resource compression")
+	ErrUnsupportedFlags       = errors.New("unsupported bitmap flags")
+	ErrCorruptChunk           = errors.New("corrupt chunk")
+	ErrUnknownChunkType       = errors.New("unknown chunk type")
+	ErrTooLarge               = errors.New("bitmap too large")
+	ErrBadBufferSize          = errors.New("buffer size doesn't match the bitmap")
+)
+
+// ErrTruncated is returned when the data ends before a structure does.
+type ErrTruncated = utils.ErrTruncated
+
+// ParseError records which file and which structure in it failed to parse.
+type ParseError struct {
+	Name   string // File name
+	Op     string // What was being parsed, e.g. "bitmap 0 header"
+	Offset int64  // Byte offset of that structure in the file
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: %s at offset %d: %v", e.Name, e.Op, e.Offset, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ChunkError reports which chunk failed to decompress and where.
+type ChunkError struct {
+	Chunk  int // Index of the chunk in ChunksHeader.Offsets
+	Offset int // Offset in the bitmap data of the code that failed
+	Err    error
+}
+
+func (e *ChunkError) Error() string {
+	return fmt.Sprintf("chunk %d at data offset %d: %v", e.Chunk, e.Offset, e.Err)
+}
+
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
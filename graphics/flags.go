@@ -0,0 +1,212 @@
+package graphics
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// flagName names one bit of a flag set.
+type flagName struct {
+	bit  uint32
+	name string
+}
+
+// formatFlags writes the set bits of v as NAME|NAME|0x2000, lowest bit
+// first. Bits nobody has identified yet are written as hex, so they stand
+// out in dumps.
+func formatFlags(v uint32, names []flagName) string {
+	if v == 0 {
+		return "0"
+	}
+
+	parts := []string{}
+	for v != 0 {
+		bit := uint32(1) << bits.TrailingZeros32(v)
+		v &^= bit
+		parts = append(parts, flagBitName(bit, names))
+	}
+	return strings.Join(parts, "|")
+}
+
+func flagBitName(bit uint32, names []flagName) string {
+	for _, n := range names {
+		if n.bit == bit {
+			return n.name
+		}
+	}
+	return fmt.Sprintf("%#x", bit)
+}
+
+// unknownFlags is the bits of v that names doesn't have.
+func unknownFlags(v uint32, names []flagName) uint32 {
+	for _, n := range names {
+		v &^= n.bit
+	}
+	return v
+}
+
+// parseFlags reads back what formatFlags wrote.
+func parseFlags(s string, names []flagName) (uint32, error) {
+	v := uint32(0)
+	for _, part := range strings.Split(s, "|") {
+		part = strings.TrimSpace(part)
+		if bit, ok := flagBit(part, names); ok {
+			v |= bit
+			continue
+		}
+		n, err := strconv.ParseUint(part, 0, 32)
+		if err != nil {
+			return 0, fmt.Errorf("unknown flag %q", part)
+		}
+		v |= uint32(n)
+	}
+	return v, nil
+}
+
+func flagBit(name string, names []flagName) (uint32, bool) {
+	for _, n := range names {
+		if n.name == name {
+			return n.bit, true
+		}
+	}
+	return 0, false
+}
+
+// BitmapFlagSet is BitmapHeader.Flags, the BM_* bits.
+type BitmapFlagSet uint32
+
+var bitmapFlagNames = []flagName{
+	{uint32(BM_8BIT), "8BIT"},
+	{uint32(BM_15BIT), "15BIT"},
+	{uint32(BM_16BIT), "16BIT"},
+	{uint32(BM_24BIT), "24BIT"},
+	{uint32(BM_32BIT), "32BIT"},
+	{uint32(BM_ZBUFFER), "ZBUFFER"},
+	{uint32(BM_NORMALS), "NORMALS"},
+	{uint32(BM_ALIAS), "ALIAS"},
+	{uint32(BM_ALPHA), "ALPHA"},
+	{uint32(BM_PALETTE), "PALETTE"},
+	{uint32(BM_REGPOINT), "REGPOINT"},
+	{uint32(BM_NOBITMAP), "NOBITMAP"},
+	{uint32(BM_5BITPAL), "5BITPAL"},
+	{uint32(BM_COMPRESSED), "COMPRESSED"},
+	{uint32(BM_CHUNKED), "CHUNKED"},
+}
+
+func (f BitmapFlagSet) String() string {
+	return formatFlags(uint32(f), bitmapFlagNames)
+}
+
+// Unknown is the bits of f that have no name yet.
+func (f BitmapFlagSet) Unknown() BitmapFlagSet {
+	return BitmapFlagSet(unknownFlags(uint32(f), bitmapFlagNames))
+}
+
+func (f BitmapFlagSet) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+func (f *BitmapFlagSet) UnmarshalText(text []byte) error {
+	v, err := parseFlags(string(text), bitmapFlagNames)
+	if err != nil {
+		return err
+	}
+	*f = BitmapFlagSet(v)
+	return nil
+}
+
+var drawingModeNames = []flagName{
+	{uint32(DM_NOCLIP), "NOCLIP"},
+	{uint32(DM_WRAPCLIP), "WRAPCLIP"},
+	{uint32(DM_WRAPCLIPSRC), "WRAPCLIPSRC"},
+	{uint32(DM_STRETCH), "STRETCH"},
+	{uint32(DM_BACKGROUND), "BACKGROUND"},
+	{uint32(DM_NORESTORE), "NORESTORE"},
+	{uint32(DM_REVERSEVERT), "REVERSEVERT"},
+	{uint32(DM_REVERSEHORZ), "REVERSEHORZ"},
+	{uint32(DM_TRANSPARENT), "TRANSPARENT"},
+	{uint32(DM_ZMASK), "ZMASK"},
+	{uint32(DM_ZBUFFER), "ZBUFFER"},
+	{uint32(DM_NORMALS), "NORMALS"},
+	{uint32(DM_TRANSLUCENT), "TRANSLUCENT"},
+}
+
+func (m DrawingMode) String() string {
+	return formatFlags(uint32(m), drawingModeNames)
+}
+
+func (m DrawingMode) Unknown() DrawingMode {
+	return DrawingMode(unknownFlags(uint32(m), drawingModeNames))
+}
+
+func (m DrawingMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *DrawingMode) UnmarshalText(text []byte) error {
+	v, err := parseFlags(string(text), drawingModeNames)
+	if err != nil {
+		return err
+	}
+	*m = DrawingMode(v)
+	return nil
+}
+
+// ImageryFlags is ImageryStateHeader.Flags. None of its bits have been
+// identified yet, they all show up as hex.
+type ImageryFlags uint32
+
+var imageryFlagNames = []flagName{}
+
+func (f ImageryFlags) String() string {
+	return formatFlags(uint32(f), imageryFlagNames)
+}
+
+func (f ImageryFlags) Unknown() ImageryFlags {
+	return ImageryFlags(unknownFlags(uint32(f), imageryFlagNames))
+}
+
+func (f ImageryFlags) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+func (f *ImageryFlags) UnmarshalText(text []byte) error {
+	v, err := parseFlags(string(text), imageryFlagNames)
+	if err != nil {
+		return err
+	}
+	*f = ImageryFlags(v)
+	return nil
+}
+
+// AnimFlags is ImageryStateHeader.Animflags and InventoryAnimFlags. None
+// of its bits have been identified yet, they all show up as hex.
+type AnimFlags uint16
+
+var animFlagNames = []flagName{}
+
+func (f AnimFlags) String() string {
+	return formatFlags(uint32(f), animFlagNames)
+}
+
+func (f AnimFlags) Unknown() AnimFlags {
+	return AnimFlags(unknownFlags(uint32(f), animFlagNames))
+}
+
+func (f AnimFlags) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+func (f *AnimFlags) UnmarshalText(text []byte) error {
+	v, err := parseFlags(string(text), animFlagNames)
+	if err != nil {
+		return err
+	}
+	if v > 0xFFFF {
+		return fmt.Errorf("anim flags %#x don't fit in 16 bits", v)
+	}
+	*f = AnimFlags(v)
+	return nil
+}
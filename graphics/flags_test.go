@@ -0,0 +1,81 @@
+package graphics
+
+import (
+	"encoding"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestBitmapFlagSetString(t *testing.T) {
+	tests := []struct {
+		flags BitmapFlagSet
+		want  string
+	}{
+		{BM_8BIT | BM_COMPRESSED | BM_CHUNKED, "8BIT|COMPRESSED|CHUNKED"},
+		{BM_15BIT | BM_ALIAS | BM_REGPOINT, "15BIT|ALIAS|REGPOINT"},
+		{0, "0"},
+		{BM_8BIT | 0x2000 | 0x10000, "8BIT|0x2000|0x10000"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.flags.String(); got != tt.want {
+			t.Errorf("%d.String() = %q, want %q", uint32(tt.flags), got, tt.want)
+		}
+
+		var back BitmapFlagSet
+		if err := back.UnmarshalText([]byte(tt.want)); err != nil || back != tt.flags {
+			t.Errorf("UnmarshalText(%q) = %d, %v, want %d", tt.want, back, err, tt.flags)
+		}
+	}
+
+	if u := (BM_8BIT | 0x2000).Unknown(); u != 0x2000 {
+		t.Errorf("Unknown() = %#x, want 0x2000", uint32(u))
+	}
+}
+
+func TestFlagsJSON(t *testing.T) {
+	bmh := BitmapHeader{Width: 40, Height: 40, Flags: BM_8BIT, DrawingMode: DM_TRANSPARENT}
+	data, err := json.Marshal(bmh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"Flags":"8BIT"`) || !strings.Contains(string(data), `"DrawingMode":"TRANSPARENT"`) {
+		t.Errorf("json = %s, want named flags", data)
+	}
+
+	var back BitmapHeader
+	if err := json.Unmarshal(data, &back); err != nil || back != bmh {
+		t.Errorf("round trip = %+v, %v, want %+v", back, err, bmh)
+	}
+
+	ish := ImageryStateHeader{Flags: 0x4, Animflags: 0x1 | 0x8}
+	data, err = json.Marshal(ish)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"Flags":"0x4"`) || !strings.Contains(string(data), `"Animflags":"0x1|0x8"`) {
+		t.Errorf("json = %s, want unknown bits as hex", data)
+	}
+
+	var badAnim AnimFlags
+	if err := badAnim.UnmarshalText([]byte("0x10000")); err == nil {
+		t.Errorf("UnmarshalText of a 17 bit value succeeded")
+	}
+}
+
+func TestUnmarshalTextError(t *testing.T) {
+	flags := BitmapFlagSet(BM_8BIT)
+	mode := DM_TRANSPARENT
+	imagery := ImageryFlags(0x4)
+	anim := AnimFlags(0x8)
+
+	for _, u := range []encoding.TextUnmarshaler{&flags, &mode, &imagery, &anim} {
+		if err := u.UnmarshalText([]byte("NOPE")); err == nil {
+			t.Errorf("%T: UnmarshalText of an unknown name succeeded", u)
+		}
+	}
+	if flags != BitmapFlagSet(BM_8BIT) || mode != DM_TRANSPARENT || imagery != 0x4 || anim != 0x8 {
+		t.Errorf("got %v, %v, %v, %v after errors, want them unchanged", flags, mode, imagery, anim)
+	}
+}
@@ -0,0 +1,87 @@
+package graphics
+
+import (
+	"testing"
+)
+
+// The parsers read files from arbitrary mods. Whatever the input, they
+// must return an error instead of panicking, and must not allocate more
+// than the MAX_* limits allow. Run one with e.g.
+//
+//	go test ./graphics -run '^$' -fuzz FuzzNewFileResource -fuzztime 1m
+
+func FuzzNewFileResourceHeader(f *testing.F) {
+	for _, seed := range synthSeeds() {
+		f.Add(seed[:FRH_SIZE])
+	}
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		NewFileResourceHeader(data)
+	})
+}
+
+func FuzzNewImageryHeader(f *testing.F) {
+	f.Add(synthImageryHeader("bread"))
+	f.Add([]byte{0, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		NewImageryHeader(data)
+	})
+}
+
+func FuzzNewBitmapHeader(f *testing.F) {
+	f.Add(synthBitmap(BitmapHeader{Width: 40, Height: 40, Flags: BM_8BIT, DrawingMode: 256, PaletteSize: 1536}, nil, nil))
+	f.Add([]byte{1, 2, 3})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		NewBitmapHeader(data)
+	})
+}
+
+func FuzzNewChunksHeader(f *testing.F) {
+	f.Add(synthChunked(2, 1, synthFilledChunk(7), nil))
+	f.Add(synthChunked(0xFFFFFFFF, 0xFFFFFFFF))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		NewChunksHeader(data)
+	})
+}
+
+func FuzzDecompressChunked(f *testing.F) {
+	f.Add(synthChunked(2, 1, synthFilledChunk(7), nil))
+	f.Add(synthChunked(1, 1, synthChunk([]byte{1, 2, 3, 4}, []byte{synthLz, 4, 0, 0})))
+	f.Add(synthChunked(1, 1, synthChunk([]byte{synthLz, 200, 0xFF, 0xFF})))
+	f.Add(synthChunked(1, 1, synthChunk([]byte{synthRle, 0x7F, 1, synthRle, 0x7F, 1})))
+	f.Add(synthChunked(1, 1, synthFilledChunkWords(0x1234)))
+	f.Add(synthChunked(1, 1, synthChunkWords([]uint16{1, 2, 3, 4, synthLz, 4, 0})))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		DecompressChunked(data)
+		DecompressChunkedWords(data)
+	})
+}
+
+func FuzzNewBitmap(f *testing.F) {
+	for _, seed := range synthSeeds() {
+		fr, err := NewFileResourceFromBytes(seed, true)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(seed[bitmapTableOffset(fr.Header)+int64(len(fr.BitmapTable))*4:])
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		NewBitmapFromBytes(data, false)
+	})
+}
+
+func FuzzNewFileResource(f *testing.F) {
+	for _, seed := range synthSeeds() {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		NewFileResourceFromBytes(data, false)
+	})
+}
@@ -0,0 +1,56 @@
+package graphics
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// BitmapImage converts the pixels of bm to an image. Pixel alpha isn't
+// premultiplied, hence NRGBA.
+func BitmapImage(bm *Bitmap) *image.NRGBA {
+	w := int(bm.Width)
+	img := image.NewNRGBA(image.Rect(0, 0, w, int(bm.Height)))
+	for i, c := range bm.Data {
+		img.SetNRGBA(i%w, i/w, color.NRGBA{c.R, c.G, c.B, c.A})
+	}
+	return img
+}
+
+// AliasMask is the alias buffer of bm as a mask, or nil if it has none.
+// Each value is how much of the pixel the sprite covers, 255 being all of
+// it. Edge pixels get partial values so the sprite blends into whatever
+// it is drawn on. The buffer must hold one byte per pixel.
+func AliasMask(bm *Bitmap) (*image.Alpha, error) {
+	if len(bm.Alias) == 0 {
+		return nil, nil
+	}
+
+	w, h := int(bm.Header.Width), int(bm.Header.Height)
+	if len(bm.Alias) != w*h {
+		return nil, fmt.Errorf("%w: %d alias bytes for %dx%d pixels", ErrBadBufferSize, len(bm.Alias), w, h)
+	}
+
+	mask := image.NewAlpha(image.Rect(0, 0, w, h))
+	copy(mask.Pix, bm.Alias)
+	return mask, nil
+}
+
+// DrawAliased draws bm onto dst at pt, blending its edges with what's
+// already in dst through the alias buffer, the way the game's blitter
+// smooths sprite edges against the background. Bitmaps without an alias
+// buffer are drawn with their own alpha only.
+func DrawAliased(dst draw.Image, pt image.Point, bm *Bitmap) error {
+	src := BitmapImage(bm)
+	mask, err := AliasMask(bm)
+	if err != nil {
+		return err
+	}
+	if mask == nil {
+		draw.Draw(dst, src.Bounds().Add(pt), src, image.Point{}, draw.Over)
+		return nil
+	}
+	draw.DrawMask(dst, mask.Bounds().Add(pt), src, image.Point{}, mask, image.Point{}, draw.Over)
+	return nil
+}
@@ -0,0 +1,62 @@
+package graphics
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestDrawAliased(t *testing.T) {
+	pixels := []byte{0x00, 0x00, 0xFF, 0x00, 0x00, 0xFF, 0x00, 0x00, 0xFF}
+	alias := []byte{0, 128, 255}
+	bmh := BitmapHeader{Width: 3, Height: 1, Flags: BM_24BIT | BM_ALIAS, AliasSize: 3}
+	bmh.AliasOffset = uint32(BMH_SIZE + len(pixels) - BMH_ALIAS_POS)
+
+	bm, err := NewBitmapFromBytes(synthBitmap(bmh, pixels, alias), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bm.Alias) != string(alias) {
+		t.Fatalf("Alias = %v, want %v", bm.Alias, alias)
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.NRGBA{0, 0, 255, 255}), image.Point{}, draw.Src)
+	if err := DrawAliased(dst, image.Point{}, &bm); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []color.NRGBA{{0, 0, 255, 255}, {128, 0, 127, 255}, {255, 0, 0, 255}}
+	for x, c := range want {
+		if got := dst.NRGBAAt(x, 0); got != c {
+			t.Errorf("pixel %d = %v, want %v", x, got, c)
+		}
+	}
+}
+
+func TestAliasSize(t *testing.T) {
+	pixels := make([]byte, 3*1*3)
+	bmh := BitmapHeader{Width: 3, Height: 1, Flags: BM_24BIT | BM_ALIAS, AliasSize: 4}
+	bmh.AliasOffset = uint32(BMH_SIZE + len(pixels) - BMH_ALIAS_POS)
+
+	_, err := NewBitmapFromBytes(synthBitmap(bmh, pixels, []byte{0, 128, 255, 255}), false)
+	var pe *ParseError
+	if !errors.Is(err, ErrBadBufferSize) || !errors.As(err, &pe) || pe.Op != "bitmap alias" {
+		t.Errorf("err = %v, want a bitmap alias *ParseError wrapping %v", err, ErrBadBufferSize)
+	}
+
+	bm := Bitmap{Header: BitmapHeader{Width: 3, Height: 1}, Alias: []byte{0, 128}}
+	if mask, err := AliasMask(&bm); mask != nil || !errors.Is(err, ErrBadBufferSize) {
+		t.Errorf("AliasMask = %v, %v, want %v", mask, err, ErrBadBufferSize)
+	}
+	if err := DrawAliased(image.NewNRGBA(image.Rect(0, 0, 3, 1)), image.Point{}, &bm); !errors.Is(err, ErrBadBufferSize) {
+		t.Errorf("DrawAliased = %v, want %v", err, ErrBadBufferSize)
+	}
+
+	bm.Alias = nil
+	if mask, err := AliasMask(&bm); mask != nil || err != nil {
+		t.Errorf("AliasMask without an alias buffer = %v, %v, want nil, nil", mask, err)
+	}
+}
@@ -0,0 +1,84 @@
+package graphics
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// The normal buffer holds one little endian uint16 per pixel, row by row,
+// for Header.Width*Header.Height pixels. The census found it in
+// Cave/cavbones2.i2d and TownInt/tincandle.i2d, but neither has been
+// checked yet, so the packing is an unverified guess: the 15 bit color
+// layout, with X, Y and Z as signed 5 bit values (bits 10-14, 5-9 and 0-4).
+// 0 is a pixel without a normal.
+const NORMAL_BYTES_PER_PIXEL = 2
+
+type Normal struct {
+	X float32
+	Y float32
+	Z float32
+}
+
+// NewNormal unpacks a normal buffer value into a unit vector. The zero
+// value stays a zero vector.
+func NewNormal(v uint16) Normal {
+	if v == 0 {
+		return Normal{}
+	}
+
+	n := Normal{signed5(v >> 10), signed5(v >> 5), signed5(v)}
+	l := float32(math.Sqrt(float64(n.X*n.X + n.Y*n.Y + n.Z*n.Z)))
+	if l == 0 {
+		return Normal{}
+	}
+	return Normal{n.X / l, n.Y / l, n.Z / l}
+}
+
+// signed5 is the low 5 bits of v as a two's complement value, -16..15,
+// scaled to -1..15/16.
+func signed5(v uint16) float32 {
+	s := int8(v&0x1F<<3) >> 3
+	return float32(s) / 16
+}
+
+func readNormals(file io.ReaderAt, offset int64, bmh BitmapHeader) ([]Normal, error) {
+	data, err := readBuffer(file, offset, bmh.NormalSize, int(bmh.Width*bmh.Height)*NORMAL_BYTES_PER_PIXEL)
+	if err != nil {
+		return nil, err
+	}
+
+	normals := make([]Normal, len(data)/NORMAL_BYTES_PER_PIXEL)
+	for i := range normals {
+		normals[i] = NewNormal(binary.LittleEndian.Uint16(data[i*2 : i*2+2]))
+	}
+	return normals, nil
+}
+
+// NormalMapImage renders the normals of bm the way normal maps are usually
+// stored: each component mapped from -1..1 to 0..255 in R, G and B, so a
+// normal facing the viewer is (128, 128, 255). Pixels without a normal are
+// transparent. Returns nil if the bitmap has no normal buffer. Until the
+// packing is checked against cavbones2.i2d, it only visualizes the buffer
+// as NewNormal guesses it.
+func NormalMapImage(bm *Bitmap) *image.NRGBA {
+	w, h := int(bm.Header.Width), int(bm.Header.Height)
+	if len(bm.Normals) == 0 || len(bm.Normals) < w*h {
+		return nil
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i, n := range bm.Normals[:w*h] {
+		if n == (Normal{}) {
+			continue
+		}
+		img.SetNRGBA(i%w, i/w, color.NRGBA{normalByte(n.X), normalByte(n.Y), normalByte(n.Z), 255})
+	}
+	return img
+}
+
+func normalByte(v float32) uint8 {
+	return uint8(math.Round(float64(v+1) * 127.5))
+}
@@ -0,0 +1,72 @@
+package graphics
+
+import (
+	"encoding/binary"
+	"image/color"
+	"testing"
+)
+
+func TestNormals(t *testing.T) {
+	// Facing the viewer, facing right, no normal
+	words := []uint16{0x000F, 0x3C00, 0}
+	ndata := []byte{}
+	for _, w := range words {
+		ndata = binary.LittleEndian.AppendUint16(ndata, w)
+	}
+
+	pixels := make([]byte, 3*3)
+	bmh := BitmapHeader{Width: 3, Height: 1, Flags: BM_24BIT | BM_NORMALS, NormalSize: uint32(len(ndata))}
+	bmh.Normal = uint32(BMH_SIZE + len(pixels) - BMH_NORMAL_POS)
+
+	bm, err := NewBitmapFromBytes(synthBitmap(bmh, pixels, ndata), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Normal{{0, 0, 1}, {1, 0, 0}, {}}
+	for i, n := range want {
+		if bm.Normals[i] != n {
+			t.Errorf("Normals[%d] = %v, want %v", i, bm.Normals[i], n)
+		}
+	}
+
+	img := NormalMapImage(&bm)
+	wantImg := []color.NRGBA{{128, 128, 255, 255}, {255, 128, 128, 255}, {}}
+	for i, c := range wantImg {
+		if got := img.NRGBAAt(i, 0); got != c {
+			t.Errorf("pixel %d = %v, want %v", i, got, c)
+		}
+	}
+}
+
+func TestNewNormalNegative(t *testing.T) {
+	// X = -15 (0x11), Y = 0, Z = 0, normalized to unit length
+	if n := NewNormal(0x11 << 10); n != (Normal{-1, 0, 0}) {
+		t.Errorf("NewNormal = %v, want {-1 0 0}", n)
+	}
+}
+
+func TestSigned5(t *testing.T) {
+	tests := []struct {
+		v    uint16
+		want float32
+	}{
+		{0x00, 0},
+		{0x01, 1.0 / 16},
+		{0x0F, 15.0 / 16},
+		{0x10, -1},
+		{0x1F, -1.0 / 16},
+		{0xFFE0, 0}, // Only the low 5 bits count
+	}
+	for _, tt := range tests {
+		if got := signed5(tt.v); got != tt.want {
+			t.Errorf("signed5(%#x) = %v, want %v", tt.v, got, tt.want)
+		}
+	}
+
+	for v := range uint16(32) {
+		if s := signed5(v); s < -1 || s > 1 {
+			t.Errorf("signed5(%#x) = %v, outside -1..1", v, s)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package graphics
+
+import (
+	"encoding/binary"
+
+	"github.com/depy/RevenantRE/utils"
+)
+
+// The palette of an 8 bit bitmap holds two tables of 256 colors (see
+// palette and palette_rgb in docs/i2d_data_format.hexpat): 15 bit
+// 0-5-5-5 words, followed by 32 bit 0x00BBGGRR values of the same colors
+// at full precision. bread.i2d has both, PaletteSize 1536.
+//
+// 8 bit bitmaps always have a palette: bread.i2d's doesn't set BM_PALETTE.
+// No census file sets the flag, so its SPalette structure is read as the
+// same two tables. Bitmaps of other depths only have one if they set it.
+const (
+	PALETTE_COLORS    = 256
+	PALETTE_5BIT_SIZE = PALETTE_COLORS * 2
+	PALETTE_8BIT_SIZE = PALETTE_COLORS * 4
+	PALETTE_SIZE      = PALETTE_5BIT_SIZE + PALETTE_8BIT_SIZE
+)
+
+type Palette struct {
+	Colors     []RGBA // The colors pixels are rendered with, one of the tables below
+	Colors5bit []RGBA // The 15 bit table, components scaled to 8 bits
+	Colors8bit []RGBA // The 32 bit table, empty if the palette doesn't have it
+}
+
+// NewPalette reads a table of 15 bit colors. The components are shifted
+// up without filling the low bits, which is how bread.i2d's 32 bit table
+// holds the same colors.
+func NewPalette(data []byte) Palette {
+	p := Palette{}
+	for i := 0; i < len(data); i += 2 {
+		c := binary.LittleEndian.Uint16(data[i : i+2])
+		pR := uint8((c>>10)&0x1F) * 8
+		pG := uint8((c>>5)&0x1F) * 8
+		pB := uint8(c&0x1F) * 8
+		p.Colors = append(p.Colors, RGBA{pR, pG, pB, 255})
+	}
+	p.Colors5bit = p.Colors
+	return p
+}
+
+// NewBitmapPalette reads a bitmap palette, with or without its 32 bit
+// table. The bitmap is rendered with the 15 bit table if is5bit (BM_5BITPAL)
+// or there's nothing else, and with the 32 bit table otherwise.
+func NewBitmapPalette(data []byte, is5bit bool) (Palette, error) {
+	if err := utils.CheckSize(data, PALETTE_5BIT_SIZE); err != nil {
+		return Palette{}, err
+	}
+
+	p := NewPalette(data[:PALETTE_5BIT_SIZE])
+	if len(data) < PALETTE_SIZE {
+		return p, nil
+	}
+
+	rgb := data[PALETTE_5BIT_SIZE:PALETTE_SIZE]
+	for i := 0; i < len(rgb); i += 4 {
+		p.Colors8bit = append(p.Colors8bit, RGBA{rgb[i], rgb[i+1], rgb[i+2], 255})
+	}
+	if !is5bit {
+		p.Colors = p.Colors8bit
+	}
+	return p, nil
+}
+
+// palettePosition finds the palette of the bitmap at bitmapOffset. Its
+// offset is relative to the PaletteOffset field, and PaletteSize says if
+// the 32 bit table is there. Headers that leave both at 0 get the 15 bit
+// table right after the pixel data.
+func palettePosition(bitmapOffset int64, bmh BitmapHeader) (int64, int) {
+	if bmh.PaletteOffset == 0 && bmh.PaletteSize == 0 {
+		return bitmapOffset + BMH_SIZE + int64(bmh.DataSize), PALETTE_5BIT_SIZE
+	}
+
+	size := PALETTE_5BIT_SIZE
+	if bmh.PaletteSize >= PALETTE_SIZE {
+		size = PALETTE_SIZE
+	}
+	return headerOffset(bitmapOffset, BMH_PALETTE_POS, bmh.PaletteOffset), size
+}
@@ -0,0 +1,116 @@
+package graphics
+
+import (
+	"testing"
+)
+
+func TestNewBitmapPalette(t *testing.T) {
+	pixels := []byte{0x0F, 0xFF}
+	tests := []struct {
+		name string
+		bmh  BitmapHeader
+		want []RGBA
+		rgb  bool
+	}{
+		{
+			name: "32 bit table like bread.i2d",
+			bmh:  BitmapHeader{Width: 2, Height: 1, Flags: BM_8BIT, PaletteSize: PALETTE_SIZE},
+			want: []RGBA{{0x0F, 0x0F, 0x0F, 255}, {0xFF, 0xFF, 0xFF, 255}},
+			rgb:  true,
+		},
+		{
+			name: "BM_5BITPAL uses the 15 bit table",
+			bmh:  BitmapHeader{Width: 2, Height: 1, Flags: BM_8BIT | BM_5BITPAL, PaletteSize: PALETTE_SIZE},
+			want: []RGBA{{0x08, 0x08, 0x08, 255}, {0xF8, 0xF8, 0xF8, 255}},
+			rgb:  true,
+		},
+		{
+			name: "15 bit table only",
+			bmh:  BitmapHeader{Width: 2, Height: 1, Flags: BM_8BIT, PaletteSize: PALETTE_5BIT_SIZE},
+			want: []RGBA{{0x08, 0x08, 0x08, 255}, {0xF8, 0xF8, 0xF8, 255}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Some padding between the data and the palette, which the
+			// offset has to skip
+			trailer := append([]byte{0xAA, 0xAA}, synthPaletteRGB()...)
+			tt.bmh.PaletteOffset = uint32(BMH_SIZE + len(pixels) + 2 - BMH_PALETTE_POS)
+
+			bm, err := NewBitmapFromBytes(synthBitmap(tt.bmh, pixels, trailer), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bm.Data[0] != tt.want[0] || bm.Data[1] != tt.want[1] {
+				t.Errorf("Data = %v, want %v", bm.Data, tt.want)
+			}
+			if len(bm.Palette.Colors5bit) != PALETTE_COLORS {
+				t.Errorf("%d 15 bit colors, want %d", len(bm.Palette.Colors5bit), PALETTE_COLORS)
+			}
+			if got := len(bm.Palette.Colors8bit) == PALETTE_COLORS; got != tt.rgb {
+				t.Errorf("has 32 bit table = %v, want %v", got, tt.rgb)
+			}
+		})
+	}
+}
+
+func TestNewBitmapPaletteBread(t *testing.T) {
+	data := breadI2D(t)
+	values := readBreadValues(t)
+
+	// palette_offset_from_here is relative to the field, 64 bytes into the header
+	bmh, err := NewBitmapHeader(data[BREAD_BITMAP_OFFSET : BREAD_BITMAP_OFFSET+BMH_SIZE])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ofs, size := palettePosition(BREAD_BITMAP_OFFSET, bmh)
+	if ofs != 0xBF8 || size != PALETTE_SIZE {
+		t.Fatalf("palette at %#x, %d bytes, want 0xbf8, %d", ofs, size, PALETTE_SIZE)
+	}
+
+	p, err := NewBitmapPalette(data[ofs:ofs+int64(size)], false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rgb := values["palette_rgb"]
+	for i, c := range values["palette"] {
+		// 0x00BBGGRR: R, G, B, 0 bytes
+		want := RGBA{byte(rgb[i]), byte(rgb[i] >> 8), byte(rgb[i] >> 16), 255}
+		if p.Colors8bit[i] != want || p.Colors[i] != want {
+			t.Errorf("color %d = %v, want %v", i, p.Colors8bit[i], want)
+		}
+		// Both tables hold the same colors
+		if p.Colors5bit[i] != want {
+			t.Errorf("15 bit color %d (%#04x) = %v, want %v", i, c, p.Colors5bit[i], want)
+		}
+	}
+}
+
+func TestPaletteFlag(t *testing.T) {
+	pixels := []byte{0xFF, 0x7F}
+	bmh := BitmapHeader{Width: 1, Height: 1, Flags: BM_15BIT | BM_PALETTE, PaletteSize: PALETTE_SIZE}
+	bmh.PaletteOffset = uint32(BMH_SIZE + len(pixels) - BMH_PALETTE_POS)
+
+	bm, err := NewBitmapFromBytes(synthBitmap(bmh, pixels, synthPaletteRGB()), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bm.Palette.Colors8bit) != PALETTE_COLORS || bm.Palette.Colors[7] != (RGBA{7, 7, 7, 255}) {
+		t.Errorf("Palette = %d colors, want the %d of the 32 bit table", len(bm.Palette.Colors), PALETTE_COLORS)
+	}
+	if bm.Data[0] != (RGBA{255, 255, 255, 255}) {
+		t.Errorf("Data = %v, want the pixel rendered without the palette", bm.Data)
+	}
+
+	// Without the flag the palette isn't read
+	bmh.Flags = BM_15BIT
+	bm, err = NewBitmapFromBytes(synthBitmap(bmh, pixels, nil), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bm.Palette.Colors) != 0 {
+		t.Errorf("Palette = %d colors, want none", len(bm.Palette.Colors))
+	}
+}
@@ -1,15 +1,32 @@
 package graphics
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
-	"os"
+	"math"
 
 	"github.com/depy/RevenantRE/utils"
 )
 
-const FRH_SIZE = 20 // File resource header size
+const (
+	FRH_SIZE = 20 // File resource header size
+	ISH_SIZE = 76 // Imagery state header size
+
+	RESOURCE_MAGIC   = 1381189443 // "CGSR"
+	RESOURCE_VERSION = 1          // bread.i2d's, the only version seen so far
+
+	// FileResourceHeader.CompType
+	RESOURCE_UNCOMPRESSED = 0
+
+	// Limits the pixels decoded from a single resource or NewBitmap call,
+	// so a small file whose bitmap table points at one huge bitmap many
+	// times can't use up all memory. Decoding takes up to 7 bytes a pixel,
+	// so this is about 56 MB
+	MAX_RESOURCE_PIXELS = 8 * 1024 * 1024
+)
 
 type FileResource struct {
 	Header      FileResourceHeader
@@ -34,11 +51,13 @@ type ImageryHeader struct {
 	ImgryStateHeaders []ImageryStateHeader
 }
 
+// ImageryStateHeader is one ISH_SIZE record of the imagery header, see
+// docs/i2d_data_format.hexpat.
 type ImageryStateHeader struct {
-	AnimName           [32]byte
+	AnimName           [32]byte // Zero terminated
 	Walkmap            uint32
-	Flags              uint32
-	Animflags          uint16
+	Flags              ImageryFlags
+	Animflags          AnimFlags
 	Frames             uint16 // Number of frames
 	MaxWidth           uint16 // Graphics maximum width/height (for IsOnScreen and refresh rects)
 	MaxHeight          uint16
@@ -54,12 +73,21 @@ type ImageryStateHeader struct {
 	WorldWidth         uint16 // Object's world width, length, and height for walk map and bound box
 	WorldLength        uint16
 	WorldHeight        uint16
-	InventoryAnimFlags uint16 // Animation flags for inventory animation
-	InventoryFrames    uint16 // Number of frames of inventory animation
+	InventoryAnimFlags AnimFlags // Animation flags for inventory animation
+	InventoryFrames    uint16    // Number of frames of inventory animation
 }
 
-func NewFileResourceHeader(data []byte) FileResourceHeader {
-	return FileResourceHeader{
+// NewFileResourceHeader parses and validates a file resource header. Files
+// that aren't resources at all (sounds, thumbnails...) fail with
+// ErrBadMagic, including ones too short to hold a header. Resources of a
+// version other than RESOURCE_VERSION fail with ErrBadVersion; the census
+// counts the versions it finds, so a second one would show up there.
+func NewFileResourceHeader(data []byte) (FileResourceHeader, error) {
+	if err := utils.CheckSize(data, FRH_SIZE); err != nil {
+		return FileResourceHeader{}, shortHeaderError(data, err)
+	}
+
+	frh := FileResourceHeader{
 		Magic:      binary.LittleEndian.Uint32(data[0:4]),
 		Topbm:      binary.LittleEndian.Uint16(data[4:6]),
 		CompType:   data[6],
@@ -68,104 +96,232 @@ func NewFileResourceHeader(data []byte) FileResourceHeader {
 		ObjSize:    binary.LittleEndian.Uint32(data[12:16]),
 		HeaderSize: binary.LittleEndian.Uint32(data[16:20]),
 	}
+
+	if frh.Magic != RESOURCE_MAGIC {
+		return frh, fmt.Errorf("%w: %#x", ErrBadMagic, frh.Magic)
+	}
+	if frh.Version != RESOURCE_VERSION {
+		return frh, fmt.Errorf("%w: %d", ErrBadVersion, frh.Version)
+	}
+	return frh, nil
+}
+
+// shortHeaderError is the error for data too short for a file resource
+// header: ErrBadMagic, unless it starts with the magic like a truncated
+// resource would.
+func shortHeaderError(data []byte, err error) error {
+	if len(data) >= 4 && binary.LittleEndian.Uint32(data) == RESOURCE_MAGIC {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrBadMagic, err)
+}
+
+// checkCompression makes sure the resource body can be read as it is.
+// Uncompressed resources store ObjSize bytes as they are, so DataSize has
+// to match. No resource with another CompType has turned up, so how they
+// are compressed isn't known and they are rejected.
+func checkCompression(frh FileResourceHeader) error {
+	if frh.CompType != RESOURCE_UNCOMPRESSED {
+		return fmt.Errorf("%w: type %d, %d bytes for %d", ErrUnsupportedCompression, frh.CompType, frh.DataSize, frh.ObjSize)
+	}
+	if frh.DataSize != frh.ObjSize {
+		return fmt.Errorf("%w: uncompressed resource stores %d bytes for %d", ErrUnsupportedCompression, frh.DataSize, frh.ObjSize)
+	}
+	return nil
 }
 
-func NewImageryHeader(data []byte) ImageryHeader {
+func NewImageryHeader(data []byte) (ImageryHeader, error) {
+	if err := utils.CheckSize(data, 8); err != nil {
+		return ImageryHeader{}, err
+	}
+
 	ih := ImageryHeader{
 		ImageryId: binary.LittleEndian.Uint32(data[0:4]),
 		NumStates: binary.LittleEndian.Uint32(data[4:8]),
 	}
 
+	// Check up front, NumStates comes straight from the file
+	if uint64(len(data)-8)/ISH_SIZE < uint64(ih.NumStates) {
+		return ImageryHeader{}, &ErrTruncated{Offset: 8, Want: int(min(uint64(ih.NumStates)*ISH_SIZE, math.MaxInt)), Got: len(data) - 8}
+	}
+
 	ih.ImgryStateHeaders = []ImageryStateHeader{}
 	for i := 0; i < int(ih.NumStates); i++ {
-		ish := ImageryStateHeader{}
-		copy(ish.AnimName[:], data[0:1])
-		// ...
+		ofs := 8 + i*ISH_SIZE
+		ish, err := NewImageryStateHeader(data[ofs : ofs+ISH_SIZE])
+		if err != nil {
+			return ih, err
+		}
 		ih.ImgryStateHeaders = append(ih.ImgryStateHeaders, ish)
 	}
 
-	return ih
+	return ih, nil
+}
+
+func NewImageryStateHeader(data []byte) (ImageryStateHeader, error) {
+	if err := utils.CheckSize(data, ISH_SIZE); err != nil {
+		return ImageryStateHeader{}, err
+	}
+
+	ish := ImageryStateHeader{
+		Walkmap:            binary.LittleEndian.Uint32(data[32:36]),
+		Flags:              ImageryFlags(binary.LittleEndian.Uint32(data[36:40])),
+		Animflags:          AnimFlags(binary.LittleEndian.Uint16(data[40:42])),
+		Frames:             binary.LittleEndian.Uint16(data[42:44]),
+		MaxWidth:           binary.LittleEndian.Uint16(data[44:46]),
+		MaxHeight:          binary.LittleEndian.Uint16(data[46:48]),
+		RegX:               binary.LittleEndian.Uint16(data[48:50]),
+		RegY:               binary.LittleEndian.Uint16(data[50:52]),
+		RegZ:               binary.LittleEndian.Uint16(data[52:54]),
+		AnimRegx:           binary.LittleEndian.Uint16(data[54:56]),
+		AnimRegy:           binary.LittleEndian.Uint16(data[56:58]),
+		AnimRegz:           binary.LittleEndian.Uint16(data[58:60]),
+		WorldRegX:          binary.LittleEndian.Uint16(data[60:62]),
+		WorldRegY:          binary.LittleEndian.Uint16(data[62:64]),
+		WorldRegZ:          binary.LittleEndian.Uint16(data[64:66]),
+		WorldWidth:         binary.LittleEndian.Uint16(data[66:68]),
+		WorldLength:        binary.LittleEndian.Uint16(data[68:70]),
+		WorldHeight:        binary.LittleEndian.Uint16(data[70:72]),
+		InventoryAnimFlags: AnimFlags(binary.LittleEndian.Uint16(data[72:74])),
+		InventoryFrames:    binary.LittleEndian.Uint16(data[74:76]),
+	}
+	copy(ish.AnimName[:], data[0:32])
+	return ish, nil
 }
 
-func NewFileResource(file *os.File, readHeadersOnly bool) (FileResource, error) {
+// Name returns AnimName up to its terminating zero.
+func (ish *ImageryStateHeader) Name() string {
+	name := ish.AnimName[:]
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+	return string(name)
+}
+
+func PrintImageryStateHeader(ish *ImageryStateHeader) {
+	fmt.Println("----- Imagery State Header -----")
+	fmt.Println("AnimName:\t", ish.Name())
+	fmt.Println("Walkmap:\t", ish.Walkmap)
+	fmt.Println("Flags:\t", ish.Flags)
+	fmt.Println("Animflags:\t", ish.Animflags)
+	fmt.Println("Frames:\t", ish.Frames)
+	fmt.Println("MaxWidth:\t", ish.MaxWidth)
+	fmt.Println("MaxHeight:\t", ish.MaxHeight)
+	fmt.Println("Reg:\t", ish.RegX, ish.RegY, ish.RegZ)
+	fmt.Println("AnimReg:\t", ish.AnimRegx, ish.AnimRegy, ish.AnimRegz)
+	fmt.Println("WorldReg:\t", ish.WorldRegX, ish.WorldRegY, ish.WorldRegZ)
+	fmt.Println("WorldSize:\t", ish.WorldWidth, ish.WorldLength, ish.WorldHeight)
+	fmt.Println("InventoryAnimFlags:\t", ish.InventoryAnimFlags)
+	fmt.Println("InventoryFrames:\t", ish.InventoryFrames)
+	fmt.Println("--------------------------------")
+}
+
+// NewFileResourceFromBytes parses a resource held in memory.
+func NewFileResourceFromBytes(data []byte, readHeadersOnly bool) (FileResource, error) {
+	return NewFileResource(bytes.NewReader(data), readHeadersOnly)
+}
+
+// NewFileResource parses a resource starting at offset 0 of r. Any
+// io.ReaderAt works: an *os.File, a vfs file, a *bytes.Reader...
+//
+// Errors are *ParseError, naming the file and the offset of the structure
+// that failed. Whatever was parsed before the failure is still returned:
+// for a compressed resource (ErrUnsupportedCompression) that is only the
+// header.
+// A bitmap whose flags can't be rendered yet doesn't stop the others: it is
+// returned with its header and no data, and its error is joined with any
+// others. So doesn't a chunked one with an unknown chunk type.
+func NewFileResource(file io.ReaderAt, readHeadersOnly bool) (FileResource, error) {
+	fr := FileResource{}
+
 	frh, err := readFileResourceHeader(file)
+	fr.Header = frh // Even on error, so the census can count versions
 	if err != nil {
-		fmt.Println("Error reading file resource header for: ", file.Name())
+		return fr, &ParseError{utils.Name(file), "file resource header", 0, err}
+	}
+
+	if err := checkCompression(frh); err != nil {
+		// Only the header: the rest can't be read without decompressing it
+		return fr, &ParseError{utils.Name(file), "file resource header", 0, err}
 	}
 
 	imageryHeader, err := readImageryHeader(frh, file)
 	if err != nil {
-		fmt.Println("Error reading imagery header for: ", file.Name())
+		return fr, &ParseError{utils.Name(file), "imagery header", FRH_SIZE, err}
 	}
-
-	frh.ImgryHeader = imageryHeader
+	fr.Header.ImgryHeader = imageryHeader
 
 	bitmapOffsets := []uint32{}
 	bitmapOffsets, err = readBitmapOffsets(frh, file, bitmapOffsets)
-
 	if err != nil {
-		fmt.Println("Error reading bitmap offsets for: ", file.Name())
+		return fr, &ParseError{utils.Name(file), "bitmap offsets", bitmapTableOffset(frh), err}
 	}
+	fr.BitmapTable = bitmapOffsets
 
 	bitmaps := []Bitmap{}
-	bitmaps, err = readBitmaps(bitmapOffsets, file, bitmaps, readHeadersOnly)
-	if err != nil {
-		fmt.Println("Error reading bitmaps for: ", file.Name())
-	}
-
-	return FileResource{Header: frh, BitmapTable: bitmapOffsets, Bitmaps: bitmaps}, nil
+	fr.Bitmaps, err = readBitmaps(frh, bitmapOffsets, file, bitmaps, readHeadersOnly)
+	return fr, err
 }
 
-func readFileResourceHeader(file *os.File) (FileResourceHeader, error) {
-	fileResHdrData, err := utils.ReadBytes(file, FRH_SIZE)
+func readFileResourceHeader(file io.ReaderAt) (FileResourceHeader, error) {
+	fileResHdrData, err := utils.ReadBytes(file, 0, FRH_SIZE)
+	var te *ErrTruncated
+	if errors.As(err, &te) {
+		magic, _ := utils.ReadBytes(file, 0, 4)
+		return FileResourceHeader{}, shortHeaderError(magic, err)
+	}
 	if err != nil {
 		return FileResourceHeader{}, err
 	}
 
-	frh := NewFileResourceHeader(fileResHdrData)
-	return frh, nil
+	return NewFileResourceHeader(fileResHdrData)
 }
 
-func readImageryHeader(frh FileResourceHeader, file *os.File) (ImageryHeader, error) {
+func readImageryHeader(frh FileResourceHeader, file io.ReaderAt) (ImageryHeader, error) {
 	if frh.HeaderSize > 0 {
-		imageryHdr, err := utils.ReadBytes(file, int(frh.HeaderSize))
+		imageryHdr, err := utils.ReadBytes(file, FRH_SIZE, int(frh.HeaderSize))
 		if err != nil {
 			return ImageryHeader{}, err
 		}
 
-		return NewImageryHeader(imageryHdr), nil
+		return NewImageryHeader(imageryHdr)
 	}
 	return ImageryHeader{}, nil
 }
 
-func readBitmaps(bitmapOffsets []uint32, file *os.File, bitmaps []Bitmap, readHeadersOnly bool) ([]Bitmap, error) {
-	currPos, err := file.Seek(0, io.SeekCurrent)
-	if err != nil {
-		return nil, err
-	}
+// bitmapTableOffset is where the bitmap offset table starts, right after
+// the imagery header.
+func bitmapTableOffset(frh FileResourceHeader) int64 {
+	return FRH_SIZE + int64(frh.HeaderSize)
+}
 
-	for i := range bitmapOffsets {
-		file.Seek(currPos, io.SeekStart)
-		bmOfs := int64(bitmapOffsets[i])
-		file.Seek(bmOfs, io.SeekCurrent)
+func readBitmaps(frh FileResourceHeader, bitmapOffsets []uint32, file io.ReaderAt, bitmaps []Bitmap, readHeadersOnly bool) ([]Bitmap, error) {
+	// Bitmap offsets are relative to the end of the offset table
+	base := bitmapTableOffset(frh) + int64(len(bitmapOffsets))*4
 
-		bm, err := NewBitmap(file, readHeadersOnly)
-		if err != nil {
-			return nil, err
+	var errs []error
+	budget := MAX_RESOURCE_PIXELS
+	for i := range bitmapOffsets {
+		bm, err := readBitmap(file, base+int64(bitmapOffsets[i]), fmt.Sprintf("bitmap %d", i), readHeadersOnly, &budget)
+		bitmaps = append(bitmaps, bm) // On error, as far as it got
+		if errors.Is(err, ErrUnsupportedFlags) || errors.Is(err, ErrUnknownChunkType) {
+			// Keep the bitmap as far as it got and go on
+			errs = append(errs, err)
+		} else if err != nil {
+			return bitmaps, errors.Join(append(errs, err)...)
 		}
-		bitmaps = append(bitmaps, bm)
 	}
-	return bitmaps, nil
+	return bitmaps, errors.Join(errs...)
 }
 
-func readBitmapOffsets(frh FileResourceHeader, file *os.File, bitmapOffsets []uint32) ([]uint32, error) {
+func readBitmapOffsets(frh FileResourceHeader, file io.ReaderAt, bitmapOffsets []uint32) ([]uint32, error) {
 	if frh.Topbm > 0 {
-		for range frh.Topbm {
-			offset, err := utils.ReadBytes(file, 4)
-			if err != nil {
-				return nil, err
-			}
-			ofs := binary.LittleEndian.Uint32(offset)
+		table, err := utils.ReadBytes(file, bitmapTableOffset(frh), int(frh.Topbm)*4)
+		if err != nil {
+			return nil, err
+		}
+		for i := range int(frh.Topbm) {
+			ofs := binary.LittleEndian.Uint32(table[i*4 : i*4+4])
 			bitmapOffsets = append(bitmapOffsets, ofs)
 		}
 	}
@@ -0,0 +1,168 @@
+package graphics
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewFileResourceValidation(t *testing.T) {
+	valid := func() []byte {
+		return synthResource(synthBitmap(BitmapHeader{Width: 1, Height: 1, Flags: BM_24BIT}, []byte{1, 2, 3}, nil))
+	}
+
+	tests := []struct {
+		name   string
+		modify func(data []byte)
+		want   error
+	}{
+		{"valid", func(data []byte) {}, nil},
+		{"not a resource", func(data []byte) { copy(data, "RIFF") }, ErrBadMagic},
+		{"other version", func(data []byte) { data[7] = 2 }, ErrBadVersion},
+		{"compressed", func(data []byte) { data[6] = 1 }, ErrUnsupportedCompression},
+		{"sizes disagree", func(data []byte) { data[12]++ }, ErrUnsupportedCompression},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := valid()
+			tt.modify(data)
+
+			_, err := NewFileResourceFromBytes(data, false)
+			if tt.want == nil && err != nil {
+				t.Fatal(err)
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+			var pe *ParseError
+			if tt.want != nil && (!errors.As(err, &pe) || pe.Op != "file resource header") {
+				t.Errorf("err = %v, want a file resource header *ParseError", err)
+			}
+		})
+	}
+}
+
+func TestNewFileResourceUnsupportedBitmap(t *testing.T) {
+	unsupported := synthBitmap(BitmapHeader{Width: 1, Height: 1, Flags: BM_24BIT | BM_COMPRESSED}, []byte{1, 2, 3}, nil)
+	plain := synthBitmap(BitmapHeader{Width: 1, Height: 1, Flags: BM_24BIT}, []byte{1, 2, 3}, nil)
+
+	fr, err := NewFileResourceFromBytes(synthResource(unsupported, plain), false)
+	if !errors.Is(err, ErrUnsupportedFlags) {
+		t.Fatalf("err = %v, want %v", err, ErrUnsupportedFlags)
+	}
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Op != "bitmap 0 data" {
+		t.Errorf("err = %v, want a bitmap 0 data *ParseError", err)
+	}
+
+	if len(fr.Bitmaps) != 2 {
+		t.Fatalf("got %d bitmaps, want 2", len(fr.Bitmaps))
+	}
+	if len(fr.Bitmaps[0].Data) != 0 || fr.Bitmaps[0].Width != 1 {
+		t.Errorf("bitmap 0: want its header and no data, got %dx%d with %d pixels", fr.Bitmaps[0].Width, fr.Bitmaps[0].Height, len(fr.Bitmaps[0].Data))
+	}
+	if len(fr.Bitmaps[1].Data) != 1 {
+		t.Errorf("bitmap 1: got %d pixels, want 1", len(fr.Bitmaps[1].Data))
+	}
+}
+
+func TestNewFileResourceBitmapIndex(t *testing.T) {
+	plain := synthBitmap(BitmapHeader{Width: 1, Height: 1, Flags: BM_24BIT}, []byte{1, 2, 3}, nil)
+	short := synthBitmap(BitmapHeader{Width: 2, Height: 1, Flags: BM_24BIT}, []byte{1, 2, 3}, nil)
+
+	_, err := NewFileResourceFromBytes(synthResource(plain, short), false)
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Op != "bitmap 1 data" {
+		t.Errorf("err = %v, want a bitmap 1 data *ParseError", err)
+	}
+}
+
+func TestNewImageryHeaderBread(t *testing.T) {
+	data := breadI2D(t)
+
+	frh, err := NewFileResourceHeader(data[:FRH_SIZE])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frh.HeaderSize != 8+ISH_SIZE {
+		t.Fatalf("HeaderSize = %d, want one state of %d bytes", frh.HeaderSize, ISH_SIZE)
+	}
+
+	ih, err := NewImageryHeader(data[FRH_SIZE : FRH_SIZE+frh.HeaderSize])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ih.ImageryId != 0 || ih.NumStates != 1 || len(ih.ImgryStateHeaders) != 1 {
+		t.Fatalf("imagery header = %+v, want id 0 with 1 state", ih)
+	}
+
+	ish := ih.ImgryStateHeaders[0]
+	if ish.Name() != "still" {
+		t.Errorf("Name() = %q, want %q", ish.Name(), "still")
+	}
+
+	want := ImageryStateHeader{AnimName: ish.AnimName, Flags: 2, MaxWidth: 24, MaxHeight: 16, RegX: 10, RegY: 7, RegZ: 12}
+	if ish != want {
+		t.Errorf("state header =\n%+v, want\n%+v", ish, want)
+	}
+
+	// A second state starts ISH_SIZE bytes after the first
+	state := data[FRH_SIZE+8 : FRH_SIZE+8+ISH_SIZE]
+	two := append([]byte{0, 0, 0, 0, 2, 0, 0, 0}, state...)
+	two = append(two, state...)
+	copy(two[8+ISH_SIZE:], "walk\x00")
+	two[8+ISH_SIZE+48] = 11 // RegX
+	ih, err = NewImageryHeader(two)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ih.ImgryStateHeaders) != 2 || ih.ImgryStateHeaders[1].Name() != "walk" || ih.ImgryStateHeaders[1].RegX != 11 || ih.ImgryStateHeaders[1].MaxWidth != 24 {
+		t.Errorf("second state = %+v, want walk with RegX 11", ih.ImgryStateHeaders[1:])
+	}
+
+	// The bitmap table follows the single state
+	if got := bitmapTableOffset(frh); got != 0x68 {
+		t.Errorf("bitmap table at %#x, want 0x68", got)
+	}
+}
+
+func TestNewFileResourceShort(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		notResource bool
+	}{
+		{"empty", nil, true},
+		{"short file", []byte("RIFF"), true},
+		{"truncated resource", []byte("CGSR\x01\x00"), false},
+	}
+
+	for _, tt := range tests {
+		_, err := NewFileResourceFromBytes(tt.data, false)
+		var te *ErrTruncated
+		if !errors.As(err, &te) {
+			t.Errorf("%s: err = %v, want it to say how short the file is", tt.name, err)
+		}
+		if got := errors.Is(err, ErrBadMagic); got != tt.notResource {
+			t.Errorf("%s: err = %v, ErrBadMagic %v, want %v", tt.name, err, got, tt.notResource)
+		}
+
+		_, err = NewFileResourceHeader(tt.data)
+		if got := errors.Is(err, ErrBadMagic); got != tt.notResource {
+			t.Errorf("%s: NewFileResourceHeader err = %v, ErrBadMagic %v, want %v", tt.name, err, got, tt.notResource)
+		}
+	}
+}
+
+func TestNewFileResourceCompressed(t *testing.T) {
+	data := synthResource(synthBitmap(BitmapHeader{Width: 1, Height: 1, Flags: BM_24BIT}, []byte{1, 2, 3}, nil))
+	data[6] = 1
+
+	fr, err := NewFileResourceFromBytes(data, false)
+	if !errors.Is(err, ErrUnsupportedCompression) {
+		t.Fatalf("err = %v, want %v", err, ErrUnsupportedCompression)
+	}
+	if fr.Header.CompType != 1 || fr.Header.Topbm != 1 || len(fr.Bitmaps) != 0 {
+		t.Errorf("got %+v, want only the header", fr)
+	}
+}
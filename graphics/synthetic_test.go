@@ -0,0 +1,178 @@
+package graphics
+
+import (
+	"encoding/binary"
+)
+
+// Builders for synthetic resources, laid out like bread.i2d (see
+// docs/i2d_data_format.hexpat), so tests don't need the game files.
+
+const synthMagic = 1381189443
+
+// synthBitmap is a bitmap header followed by its pixel data and whatever
+// comes after the data (palette, alpha buffer...).
+func synthBitmap(bmh BitmapHeader, data []byte, trailer []byte) []byte {
+	bmh.DataSize = uint32(len(data))
+	fields := []uint32{
+		bmh.Width, bmh.Height, bmh.RegPointX, bmh.RegPointY, uint32(bmh.Flags), uint32(bmh.DrawingMode), bmh.KeyColor,
+		bmh.AliasSize, bmh.AliasOffset, bmh.AlphaSize, bmh.Alpha, bmh.ZBufferSize, bmh.ZBuffer,
+		bmh.NormalSize, bmh.Normal, bmh.PaletteSize, bmh.PaletteOffset, bmh.DataSize,
+	}
+
+	buf := []byte{}
+	for _, f := range fields {
+		buf = binary.LittleEndian.AppendUint32(buf, f)
+	}
+	buf = append(buf, data...)
+	return append(buf, trailer...)
+}
+
+// synthImageryHeader is an imagery header with a single state named name.
+func synthImageryHeader(name string) []byte {
+	buf := binary.LittleEndian.AppendUint32(nil, 0) // Imagery id
+	buf = binary.LittleEndian.AppendUint32(buf, 1)  // Number of states
+	state := make([]byte, ISH_SIZE)
+	copy(state[0:32], name)
+	return append(buf, state...)
+}
+
+// synthResource wraps bitmaps into a file resource.
+func synthResource(bitmaps ...[]byte) []byte {
+	imgryHdr := synthImageryHeader("synthetic")
+
+	table := []byte{}
+	body := []byte{}
+	for _, bm := range bitmaps {
+		table = binary.LittleEndian.AppendUint32(table, uint32(len(body)))
+		body = append(body, bm...)
+	}
+	size := uint32(len(imgryHdr) + len(table) + len(body))
+
+	buf := binary.LittleEndian.AppendUint32(nil, synthMagic)
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(bitmaps)))
+	buf = append(buf, 0, 1) // Compression type, version
+	buf = binary.LittleEndian.AppendUint32(buf, size)
+	buf = binary.LittleEndian.AppendUint32(buf, size)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(imgryHdr)))
+	buf = append(buf, imgryHdr...)
+	buf = append(buf, table...)
+	return append(buf, body...)
+}
+
+// synthPalette is a 15 bit palette where color i is (i, i, i) scaled to 5 bits.
+func synthPalette() []byte {
+	buf := []byte{}
+	for i := range 256 {
+		c := uint16(i >> 3)
+		buf = binary.LittleEndian.AppendUint16(buf, c<<10|c<<5|c)
+	}
+	return buf
+}
+
+// synthPaletteRGB is synthPalette followed by the 32 bit table with the
+// unscaled colors, like bread.i2d's 1536 byte palette.
+func synthPaletteRGB() []byte {
+	buf := synthPalette()
+	for i := range 256 {
+		buf = append(buf, byte(i), byte(i), byte(i), 0)
+	}
+	return buf
+}
+
+const (
+	synthRle = 0xFE
+	synthLz  = 0xFD
+)
+
+// synthChunk compresses rows of codes into a chunk. Each row gets the end
+// of line code appended.
+func synthChunk(rows ...[]byte) []byte {
+	buf := []byte{1, 0, 0, 0, synthRle, synthLz}
+	for _, r := range rows {
+		buf = append(buf, r...)
+		buf = append(buf, synthRle, 0)
+	}
+	return buf
+}
+
+// synthFilledChunk is a chunk where every pixel is val.
+func synthFilledChunk(val byte) []byte {
+	rows := [][]byte{}
+	for range CHUNK_HEIGHT {
+		rows = append(rows, []byte{synthRle, CHUNK_WIDTH, val})
+	}
+	return synthChunk(rows...)
+}
+
+// synthChunkWords compresses rows of 16 bit codes into a chunk, see
+// decodeWords.
+func synthChunkWords(rows ...[]uint16) []byte {
+	buf := []byte{1, 0, 0, 0, synthRle, synthLz}
+	for _, r := range rows {
+		for _, w := range append(r, synthRle, 0) {
+			buf = binary.LittleEndian.AppendUint16(buf, w)
+		}
+	}
+	return buf
+}
+
+// synthFilledChunkWords is a 16 bit chunk where every pixel is val.
+func synthFilledChunkWords(val uint16) []byte {
+	rows := [][]uint16{}
+	for range CHUNK_HEIGHT {
+		rows = append(rows, []uint16{synthRle, CHUNK_WIDTH, val})
+	}
+	return synthChunkWords(rows...)
+}
+
+// synthChunked lays out chunks with a chunks header. nil chunks are empty.
+func synthChunked(width uint32, height uint32, chunks ...[]byte) []byte {
+	buf := binary.LittleEndian.AppendUint32(nil, 0) // Type
+	buf = binary.LittleEndian.AppendUint32(buf, width)
+	buf = binary.LittleEndian.AppendUint32(buf, height)
+
+	body := []byte{}
+	base := 12 + 4*len(chunks)
+	for i, c := range chunks {
+		if c == nil {
+			buf = binary.LittleEndian.AppendUint32(buf, 0)
+			continue
+		}
+		// Offsets are relative to where the offset itself is stored
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(base+len(body)-(12+4*i)))
+		body = append(body, c...)
+	}
+	return append(buf, body...)
+}
+
+// synthSeeds is a set of valid resources covering the bitmap types the
+// package decodes.
+func synthSeeds() [][]byte {
+	pixels8 := []byte{0, 1, 2, 3, 4, 5}
+	pixels15 := []byte{0xFF, 0x7F, 0x00, 0x00, 0x1F, 0x00, 0xE0, 0x03}
+	chunked := synthChunked(2, 1, synthFilledChunk(7), nil)
+	lz := synthChunked(1, 1, synthChunk(
+		[]byte{1, 2, 3, 4, 5, 6, 7, 8},
+		[]byte{synthLz, 8, 4, 0, synthRle, 0x80 | 4, 9},
+	))
+
+	plain := synthChunk([]byte{1, synthRle, 2, 3}, []byte{synthLz, 3, 0, 0})
+
+	pixels16 := []byte{0x00, 0xF8, 0xE0, 0x07, 0x1F, 0x00, 0xFF, 0xFF}
+	chunked16 := synthChunked(1, 2, nil, synthFilledChunkWords(0xF81F))
+
+	return [][]byte{
+		synthResource(synthBitmap(BitmapHeader{Width: 3, Height: 2, Flags: BM_8BIT}, pixels8, synthPalette())),
+		synthResource(synthBitmap(BitmapHeader{Width: 3, Height: 2, Flags: BM_8BIT, DrawingMode: DM_TRANSPARENT,
+			PaletteSize: PALETTE_SIZE, PaletteOffset: uint32(BMH_SIZE + len(pixels8) - BMH_PALETTE_POS)}, pixels8, synthPaletteRGB())),
+		synthResource(synthBitmap(BitmapHeader{Width: 2, Height: 2, Flags: BM_15BIT}, pixels15, nil)),
+		synthResource(synthBitmap(BitmapHeader{Width: 2, Height: 1, Flags: BM_8BIT | BM_COMPRESSED | BM_CHUNKED}, chunked, synthPalette())),
+		synthResource(synthBitmap(BitmapHeader{Width: 1, Height: 1, Flags: BM_8BIT | BM_COMPRESSED | BM_CHUNKED}, lz, synthPalette())),
+		synthResource(synthBitmap(BitmapHeader{Width: 3, Height: 2, Flags: BM_8BIT | BM_COMPRESSED}, plain, synthPalette())),
+		synthResource(synthBitmap(BitmapHeader{Width: 2, Height: 2, Flags: BM_16BIT}, pixels16, nil)),
+		synthResource(synthBitmap(BitmapHeader{Width: 1, Height: 2, Flags: BM_16BIT | BM_COMPRESSED | BM_CHUNKED}, chunked16, nil)),
+		synthResource(synthBitmap(BitmapHeader{Width: 2, Height: 1, Flags: BM_15BIT | BM_COMPRESSED | BM_CHUNKED}, synthChunked(2, 1, synthFilledChunkWords(0x7C00), nil), nil)),
+		synthResource(synthBitmap(BitmapHeader{Width: 2, Height: 1, Flags: BM_24BIT}, []byte{1, 2, 3, 4, 5, 6}, nil)),
+		synthResource(synthBitmap(BitmapHeader{Width: 1, Height: 2, Flags: BM_32BIT}, []byte{1, 2, 3, 4, 5, 6, 7, 8}, nil)),
+	}
+}
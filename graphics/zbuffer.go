@@ -0,0 +1,55 @@
+package graphics
+
+import (
+	"encoding/binary"
+	"image"
+	"io"
+)
+
+// The Z buffer holds one little endian uint16 depth per pixel, row by row,
+// for Header.Width*Header.Height pixels. The game uses it to sort
+// pre-rendered objects against each other and against characters. The
+// uint16 layout is an assumption, it hasn't been checked against a game
+// bitmap with a Z buffer yet.
+//
+// For chunked bitmaps it covers the header size, not the padded chunk grid,
+// like Data.
+const ZBUFFER_BYTES_PER_PIXEL = 2
+
+func readZBuffer(file io.ReaderAt, offset int64, bmh BitmapHeader) ([]uint16, error) {
+	data, err := readBuffer(file, offset, bmh.ZBufferSize, int(bmh.Width*bmh.Height)*ZBUFFER_BYTES_PER_PIXEL)
+	if err != nil {
+		return nil, err
+	}
+
+	zbuf := make([]uint16, len(data)/ZBUFFER_BYTES_PER_PIXEL)
+	for i := range zbuf {
+		zbuf[i] = binary.LittleEndian.Uint16(data[i*2 : i*2+2])
+	}
+	return zbuf, nil
+}
+
+// ZBufferImage renders the Z buffer of bm as grayscale. The depths are
+// stretched so the smallest one is black and the largest one white, as the
+// values in a single bitmap usually only span a small part of the range.
+// Returns nil if the bitmap has no Z buffer.
+func ZBufferImage(bm *Bitmap) *image.Gray {
+	w, h := int(bm.Header.Width), int(bm.Header.Height)
+	if len(bm.ZBuffer) == 0 || len(bm.ZBuffer) < w*h {
+		return nil
+	}
+
+	lo, hi := bm.ZBuffer[0], bm.ZBuffer[0]
+	for _, z := range bm.ZBuffer {
+		lo = min(lo, z)
+		hi = max(hi, z)
+	}
+
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for i, z := range bm.ZBuffer[:w*h] {
+		if hi > lo {
+			img.Pix[i] = uint8(uint32(z-lo) * 255 / uint32(hi-lo))
+		}
+	}
+	return img
+}
@@ -0,0 +1,65 @@
+package graphics
+
+import (
+	"encoding/binary"
+	"image"
+	"slices"
+	"testing"
+)
+
+func TestZBuffer(t *testing.T) {
+	depths := []uint16{100, 300, 200, 500}
+	zdata := []byte{}
+	for _, z := range depths {
+		zdata = binary.LittleEndian.AppendUint16(zdata, z)
+	}
+
+	pixels := make([]byte, 2*2*3)
+	bmh := BitmapHeader{Width: 2, Height: 2, Flags: BM_24BIT | BM_ZBUFFER, ZBufferSize: uint32(len(zdata))}
+	bmh.ZBuffer = uint32(BMH_SIZE + len(pixels) - BMH_ZBUFFER_POS)
+
+	bm, err := NewBitmapFromBytes(synthBitmap(bmh, pixels, zdata), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(bm.ZBuffer, depths) {
+		t.Fatalf("ZBuffer = %v, want %v", bm.ZBuffer, depths)
+	}
+
+	img := ZBufferImage(&bm)
+	want := []uint8{0, 127, 63, 255}
+	if img == nil || !slices.Equal(img.Pix, want) {
+		t.Errorf("ZBufferImage = %v, want %v", img, want)
+	}
+}
+
+func TestZBufferChunked(t *testing.T) {
+	depths := []uint16{10, 20, 30, 40, 50, 60}
+	zdata := []byte{}
+	for _, z := range depths {
+		zdata = binary.LittleEndian.AppendUint16(zdata, z)
+	}
+
+	// A 3x2 bitmap decoded from a single 64x64 chunk
+	chunked := synthChunked(1, 1, synthFilledChunk(7))
+	trailer := append(synthPalette(), zdata...)
+	bmh := BitmapHeader{Width: 3, Height: 2, Flags: BM_8BIT | BM_COMPRESSED | BM_CHUNKED | BM_ZBUFFER, ZBufferSize: uint32(len(zdata))}
+	bmh.ZBuffer = uint32(BMH_SIZE + len(chunked) + PALETTE_5BIT_SIZE - BMH_ZBUFFER_POS)
+
+	bm, err := NewBitmapFromBytes(synthBitmap(bmh, chunked, trailer), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := ZBufferImage(&bm)
+	if img == nil {
+		t.Fatal("ZBufferImage = nil")
+	}
+	if img.Bounds() != image.Rect(0, 0, 3, 2) || img.Bounds() != bm.Content.Sub(bm.Content.Min) {
+		t.Errorf("Bounds() = %v, want the %v of Data", img.Bounds(), bm.Content)
+	}
+	want := []uint8{0, 51, 102, 153, 204, 255}
+	if !slices.Equal(img.Pix, want) {
+		t.Errorf("Pix = %v, want %v", img.Pix, want)
+	}
+}
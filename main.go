@@ -1,16 +1,21 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"image"
 	"image/color"
+	"image/draw"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/depy/RevenantRE/graphics"
 	s "github.com/depy/RevenantRE/state"
 	"github.com/depy/RevenantRE/ui"
+	"github.com/depy/RevenantRE/vfs"
 	"github.com/ebitenui/ebitenui"
 	"github.com/hajimehoshi/ebiten/v2"
 )
@@ -52,13 +57,44 @@ func (g *Game) Layout(outsideWidth, outsideHeight int) (int, int) {
 	return screenWidth, screenHeight
 }
 
+func openFile(layers string, fpath string) (vfs.File, error) {
+	if layers == "" {
+		return os.Open(fpath)
+	}
+
+	fsys, err := vfs.Mount(strings.Split(layers, ",")...)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := vfs.OpenFile(fsys, path.Clean(strings.ReplaceAll(fpath, "\\", "/")))
+	if err != nil {
+		fsys.Close()
+		return nil, err
+	}
+	return &mountedFile{file, fsys}, nil
+}
+
+// mountedFile unmounts the layers it was opened from when closed.
+type mountedFile struct {
+	vfs.File
+	fsys *vfs.FS
+}
+
+func (mf *mountedFile) Close() error {
+	return errors.Join(mf.File.Close(), mf.fsys.Close())
+}
+
 func main() {
 	fpath := flag.String("f", "", "Filename to open")
+	layers := flag.String("a", "", "Directories to open the -f file from, comma separated, later ones shadow earlier ones")
+	show := flag.String("show", "image", "What to show of the bitmap: image, aliased (edges blended over gray through the alias buffer), zbuffer or normals (unverified packing)")
 	flag.Parse()
 
 	if *fpath == "" {
 		println("File path must be specified with -f flag.")
 		println("For example: RevenantRE -f imagery/Imagery/Forest/breaktable.i2d")
+		println("With a mod directory on top: RevenantRE -a imagery,mymod -f Imagery/Forest/breaktable.i2d")
 		return
 	}
 
@@ -66,29 +102,50 @@ func main() {
 		println("This program can only open .dat and .i2d files.")
 	}
 
-	file, err := os.Open(*fpath)
-
+	file, err := openFile(*layers, *fpath)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	fr, err := graphics.NewFileResource(file, false)
+	file.Close()
 	if err != nil {
-		log.Fatal(err)
-		return
+		// Still show whatever was decoded, e.g. a bitmap with a corrupt chunk
+		log.Println(err)
 	}
 
-	bm := fr.Bitmaps[0]
-
-	img := image.NewRGBA(image.Rect(0, 0, int(bm.Width), int(bm.Height)))
-	for i := 0; i < len(bm.Data); i++ {
-		x := i % int(bm.Width)
-		y := i / int(bm.Width)
-		c := color.RGBA{bm.Data[i].R, bm.Data[i].G, bm.Data[i].B, bm.Data[i].A}
-		img.Set(x, y, c)
+	if len(fr.Bitmaps) == 0 || len(fr.Bitmaps[0].Data) == 0 {
+		log.Fatal(*fpath, ": nothing to display")
 	}
 
+	bm := fr.Bitmaps[0]
+
+	img := graphics.BitmapImage(&bm)
 	eImg := ebiten.NewImageFromImage(img)
+	switch *show {
+	case "image":
+	case "aliased":
+		aImg := image.NewNRGBA(img.Bounds())
+		draw.Draw(aImg, aImg.Bounds(), image.NewUniform(color.Gray{0x80}), image.Point{}, draw.Src)
+		if err := graphics.DrawAliased(aImg, image.Point{}, &bm); err != nil {
+			log.Fatal(*fpath, ": ", err)
+		}
+		eImg = ebiten.NewImageFromImage(aImg)
+	case "zbuffer":
+		zImg := graphics.ZBufferImage(&bm)
+		if zImg == nil {
+			log.Fatal(*fpath, ": bitmap has no z buffer")
+		}
+		eImg = ebiten.NewImageFromImage(zImg)
+	case "normals":
+		nImg := graphics.NormalMapImage(&bm)
+		if nImg == nil {
+			log.Fatal(*fpath, ": bitmap has no normal buffer")
+		}
+		eImg = ebiten.NewImageFromImage(nImg)
+	default:
+		log.Fatal("unknown -show ", *show)
+	}
 
 	palette := image.NewRGBA(image.Rect(0, 0, 16, 16))
 	for i := 0; i < len(bm.Palette.Colors); i++ {
@@ -1,12 +1,67 @@
 package utils
 
-import "os"
+import (
+	"errors"
+	"fmt"
+	"io"
+)
 
-func ReadBytes(file *os.File, n int) ([]byte, error) {
-	buf := make([]byte, n)
-	_, err := file.Read(buf)
-	if err != nil {
+// ErrTruncated is returned when the data ends before a structure does.
+type ErrTruncated struct {
+	Offset int64 // Where the read started
+	Want   int   // Bytes needed
+	Got    int   // Bytes available
+}
+
+func (e *ErrTruncated) Error() string {
+	return fmt.Sprintf("truncated: want %d bytes at offset %d, got %d", e.Want, e.Offset, e.Got)
+}
+
+// Sizes come straight from file headers, so reads bigger than this are
+// done in steps to avoid allocating gigabytes for a garbage size.
+const readStep = 1 << 20
+
+// ReadBytes reads n bytes at offset. It doesn't depend on or move any
+// seek position, so the same reader can be shared between parsers.
+// A short read is reported as *ErrTruncated.
+func ReadBytes(r io.ReaderAt, offset int64, n int) ([]byte, error) {
+	if n < 0 || offset < 0 {
+		return nil, &ErrTruncated{Offset: offset, Want: n}
+	}
+
+	// bytes.Reader and io.SectionReader know their size
+	if sr, ok := r.(interface{ Size() int64 }); ok && offset+int64(n) > sr.Size() {
+		return nil, &ErrTruncated{Offset: offset, Want: n, Got: int(max(0, min(sr.Size()-offset, int64(n))))}
+	}
+
+	buf := make([]byte, 0, min(n, readStep))
+	for len(buf) < n {
+		step := min(n-len(buf), readStep)
+		buf = append(buf, make([]byte, step)...)
+		got, err := r.ReadAt(buf[len(buf)-step:], offset+int64(len(buf)-step))
+		if got == step {
+			continue
+		}
+		if err == nil || errors.Is(err, io.EOF) {
+			return nil, &ErrTruncated{Offset: offset, Want: n, Got: len(buf) - step + got}
+		}
 		return nil, err
 	}
 	return buf, nil
 }
+
+// CheckSize reports *ErrTruncated if data is shorter than n bytes.
+func CheckSize(data []byte, n int) error {
+	if len(data) < n {
+		return &ErrTruncated{Want: n, Got: len(data)}
+	}
+	return nil
+}
+
+// Name returns the file name of r, if it has one.
+func Name(r any) string {
+	if n, ok := r.(interface{ Name() string }); ok {
+		return n.Name()
+	}
+	return "<unnamed>"
+}
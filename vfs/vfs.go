@@ -0,0 +1,225 @@
+package vfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// FS layers several file systems on top of each other, the way a mod
+// loader would: a file in a later layer shadows the same path in earlier
+// layers, and directories list the union of all layers. Names are looked
+// up case insensitively in every layer, like the game does, so
+// "imagery/forest/BREAD.I2D" finds Imagery/Forest/bread.i2d in any of
+// them.
+type FS struct {
+	layers []fs.FS
+}
+
+func New(layers ...fs.FS) *FS {
+	return &FS{layers: layers}
+}
+
+// Mount opens each path, a loose file directory, as a layer.
+// For example: Mount("imagery", "mymod").
+func Mount(paths ...string) (*FS, error) {
+	vfs := &FS{}
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%s: not a directory", p)
+		}
+		vfs.layers = append(vfs.layers, os.DirFS(p))
+	}
+	return vfs, nil
+}
+
+// Close closes the layers that need closing.
+func (vfs *FS) Close() error {
+	var errs []error
+	for _, l := range vfs.layers {
+		if c, ok := l.(io.Closer); ok {
+			errs = append(errs, c.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (vfs *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	var dirs []fs.File
+	for i := len(vfs.layers) - 1; i >= 0; i-- {
+		f, err := openFold(vfs.layers[i], name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			closeAll(dirs)
+			return nil, err
+		}
+
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			closeAll(dirs)
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			if len(dirs) > 0 {
+				// A later layer has a directory here, which shadows this file
+				f.Close()
+				break
+			}
+			return f, nil
+		}
+		dirs = append(dirs, f)
+	}
+
+	if len(dirs) == 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return newMergedDir(dirs)
+}
+
+// openFold opens name in fsys, matching each path element case
+// insensitively when there is no exact match. An exact match wins over a
+// folded one.
+func openFold(fsys fs.FS, name string) (fs.File, error) {
+	f, err := fsys.Open(name)
+	if !errors.Is(err, fs.ErrNotExist) || name == "." {
+		return f, err
+	}
+
+	dir := "."
+	for _, elem := range strings.Split(name, "/") {
+		des, err := fs.ReadDir(fsys, dir)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+
+		found := ""
+		for _, de := range des {
+			if de.Name() == elem {
+				found = elem
+				break
+			}
+			if found == "" && strings.EqualFold(de.Name(), elem) {
+				found = de.Name()
+			}
+		}
+		if found == "" {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		dir = path.Join(dir, found)
+	}
+	return fsys.Open(dir)
+}
+
+// mergedDir lists a directory present in one or more layers. dirs are
+// ordered from the topmost layer down.
+type mergedDir struct {
+	fs.File
+	entries []fs.DirEntry
+}
+
+func newMergedDir(dirs []fs.File) (*mergedDir, error) {
+	defer closeAll(dirs[1:])
+
+	seen := map[string]bool{}
+	entries := []fs.DirEntry{}
+	for _, d := range dirs {
+		rd, ok := d.(fs.ReadDirFile)
+		if !ok {
+			continue
+		}
+
+		des, err := rd.ReadDir(-1)
+		if err != nil {
+			closeAll(dirs[:1])
+			return nil, err
+		}
+
+		// "imagery/forest" in a mod directory and "Imagery/Forest" in the
+		// game data are the same directory, list its entries once.
+		for _, de := range des {
+			if !seen[strings.ToLower(de.Name())] {
+				seen[strings.ToLower(de.Name())] = true
+				entries = append(entries, de)
+			}
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return &mergedDir{dirs[0], entries}, nil
+}
+
+func (md *mergedDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := md.entries
+		md.entries = nil
+		return entries, nil
+	}
+	if len(md.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(md.entries))
+	entries := md.entries[:n]
+	md.entries = md.entries[n:]
+	return entries, nil
+}
+
+// File is what the graphics package needs to parse a file: random access
+// reads, and a Close when done.
+type File interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// OpenFile opens name for parsing with the graphics package. Files that
+// don't support random access are read into memory.
+func OpenFile(fsys fs.FS, name string) (File, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if ra, ok := f.(File); ok {
+		return ra, nil
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return &namedReader{bytes.NewReader(data), name}, nil
+}
+
+type namedReader struct {
+	*bytes.Reader
+	name string
+}
+
+func (nr *namedReader) Name() string { return nr.name }
+func (nr *namedReader) Close() error { return nil }
+
+func closeAll(files []fs.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
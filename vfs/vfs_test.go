@@ -0,0 +1,82 @@
+package vfs
+
+import (
+	"io"
+	"io/fs"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func testLayers() *FS {
+	base := fstest.MapFS{
+		"Imagery/Forest/bread.i2d":    {Data: []byte("base bread")},
+		"Imagery/Forest/forbirch.i2d": {Data: []byte("base birch")},
+		"Imagery/Town/twnlight.i2d":   {Data: []byte("base light")},
+	}
+	mod := fstest.MapFS{
+		"imagery/forest/BREAD.I2D":   {Data: []byte("mod bread")},
+		"imagery/forest/newtree.i2d": {Data: []byte("mod tree")},
+	}
+	return New(base, mod)
+}
+
+func TestOpenShadowsCaseInsensitively(t *testing.T) {
+	vfs := testLayers()
+
+	tests := []struct {
+		name, want string
+	}{
+		{"Imagery/Forest/bread.i2d", "mod bread"},
+		{"IMAGERY/FOREST/bread.i2d", "mod bread"},
+		{"Imagery/Forest/forbirch.i2d", "base birch"},
+		{"imagery/town/TWNLIGHT.I2D", "base light"},
+		{"Imagery/Forest/newtree.i2d", "mod tree"},
+	}
+	for _, tt := range tests {
+		data, err := fs.ReadFile(vfs, tt.name)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(data) != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, data, tt.want)
+		}
+	}
+
+	if _, err := vfs.Open("Imagery/Forest/missing.i2d"); err == nil {
+		t.Error("opened a file no layer has")
+	}
+}
+
+func TestReadDirMergesLayers(t *testing.T) {
+	des, err := fs.ReadDir(testLayers(), "Imagery/Forest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, de := range des {
+		names = append(names, de.Name())
+	}
+	want := []string{"BREAD.I2D", "forbirch.i2d", "newtree.i2d"}
+	if !slices.Equal(names, want) {
+		t.Errorf("ReadDir = %v, want %v", names, want)
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	f, err := OpenFile(testLayers(), "imagery/forest/bread.i2d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.NewSectionReader(f, 4, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "bread" {
+		t.Errorf("ReadAt = %q, want %q", data, "bread")
+	}
+}